@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/ankon/explain-cloudformation-changeset/internal/util"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/spf13/cobra"
+)
+
+// Exit code when a deny rule of the policy matches, distinct from the generic failure exit code 1
+const policyViolationExitCode = 3
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check a changeset against a policy",
+	Long: `This command checks all changes of a changeset and its nested changesets against the rules of a YAML policy.
+
+It exits with code 3 when a deny rule matches a change, so that it can be used to gate deployments.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCommand(cmd, check)
+	},
+	Version: version,
+}
+
+var policyFile string
+
+func init() {
+	checkCmd.Flags().StringVarP(&policyFile, "policy", "p", "", "YAML policy file")
+	checkCmd.MarkFlagRequired("policy")
+
+	rootCmd.AddCommand(checkCmd)
+}
+
+func describeViolation(violation *util.PolicyViolation) string {
+	rc := violation.Resource.ResourceChange()
+	action := string(rc.Action)
+	if rc.Replacement == types.ReplacementTrue || rc.Replacement == types.ReplacementConditional {
+		action = fmt.Sprintf("%s (Replacement: %s)", action, rc.Replacement)
+	}
+	description := fmt.Sprintf("%s %s: %s %s %s", strings.ToUpper(string(violation.Rule.Effect)), violation.Rule.Name, violation.Resource.Id(), aws.ToString(rc.ResourceType), action)
+	if violation.Rule.Message != "" {
+		description += ": " + violation.Rule.Message
+	}
+	return description
+}
+
+func check(ctx context.Context) error {
+	if changeSetName == "" {
+		flag.PrintDefaults()
+		return fmt.Errorf("must provide change set name")
+	}
+
+	// A gate must see all changes, filters would silently let changes through
+	if !changeFilter.IsEmpty() {
+		return fmt.Errorf("cannot use filters (%s) with the check command", changeFilterFlags)
+	}
+
+	policy, err := util.LoadPolicy(policyFile)
+	if err != nil {
+		return err
+	}
+
+	svc, err := newClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	model, err := loadChangeSetModel(ctx, svc)
+	if err != nil {
+		return err
+	}
+
+	denied := 0
+	for _, violation := range policy.Evaluate(model) {
+		fmt.Println(describeViolation(violation))
+		if violation.Rule.Effect == util.PolicyEffectDeny {
+			denied++
+		}
+	}
+	if denied > 0 {
+		return &exitCodeError{code: policyViolationExitCode, err: fmt.Errorf("%d change(s) denied by policy %s", denied, policyFile)}
+	}
+
+	// Without violations the check is only conclusive if all stacks could be checked
+	return failedStacksError(model)
+}
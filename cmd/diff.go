@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ankon/explain-cloudformation-changeset/internal/util"
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff OTHER-CHANGE-SET",
+	Short: "Compare two changesets",
+	Long: `This command compares the changeset given with --change-set-name to another changeset, and reports the resources and cause edges that differ between them.
+
+Both changesets can be given as name, ARN, or as the path to a cached changeset description (*.json). Nested changesets are matched by the logical ids of their stack resources. When the graph is written to standard output, the differences are written to standard error unless --diff-output is given.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCommand(cmd, func(ctx context.Context) error {
+			return diff(ctx, args[0])
+		})
+	},
+	Version: version,
+}
+
+var diffFile string
+var diffGraphFile string
+var diffGraphFormat string
+
+func init() {
+	diffCmd.Flags().StringVarP(&diffFile, "diff-output", "o", "", "File to write the differences to (default: standard output)")
+	diffCmd.Flags().StringVar(&diffGraphFile, "graph-output", "", "File to write a graph of both changesets with the differences highlighted (should be using .dot/.svg/.png/.jpg extension)")
+	diffCmd.Flags().StringVar(&diffGraphFormat, "format", "", "Graph format, one of dot, and when built with Graphviz support xdot, svg, png, jpg (default: derived from the graph output file extension)")
+
+	rootCmd.AddCommand(diffCmd)
+}
+
+// Load a changeset by name or ARN, or from the cache file if the name is the path of an existing JSON file
+func loadChangeSetModelFrom(ctx context.Context, name string) (*util.ChangeSetModel, error) {
+	if strings.HasSuffix(name, ".json") {
+		if _, err := os.Stat(name); err == nil {
+			svc, err := newClientWithCacheDir(ctx, filepath.Dir(name))
+			if err != nil {
+				return nil, err
+			}
+			return loadNamedChangeSetModel(ctx, svc, strings.TrimSuffix(filepath.Base(name), ".json"))
+		}
+	}
+	svc, err := newClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return loadNamedChangeSetModel(ctx, svc, name)
+}
+
+func diff(ctx context.Context, otherChangeSetName string) (err error) {
+	if changeSetName == "" {
+		flag.PrintDefaults()
+		return fmt.Errorf("must provide change set name")
+	}
+
+	oldModel, err := loadChangeSetModelFrom(ctx, changeSetName)
+	if err != nil {
+		return err
+	}
+	newModel, err := loadChangeSetModelFrom(ctx, otherChangeSetName)
+	if err != nil {
+		return err
+	}
+
+	result := util.DiffChangeSetModels(oldModel, newModel)
+
+	format := graphFormatFor(diffGraphFile, diffGraphFormat)
+
+	// Keep standard output for the graph when it is written there
+	graphToStdout := diffGraphFile == "" && (format == "dot" || format == "gv")
+	var out io.WriteCloser = nopWriteCloser{os.Stderr}
+	if diffFile != "" || !graphToStdout {
+		out, err = createOutput(diffFile)
+		if err != nil {
+			return err
+		}
+	}
+	defer closeOutput(out, &err)
+
+	if err := util.WriteDiffText(out, result, changeSetName, otherChangeSetName); err != nil {
+		return err
+	}
+
+	switch format {
+	case "":
+		// No graph wanted
+	case "dot", "gv":
+		var graphOut io.WriteCloser
+		graphOut, err = createOutput(diffGraphFile)
+		if err != nil {
+			return err
+		}
+		defer closeOutput(graphOut, &err)
+
+		if err := util.WriteDiffDOTGraph(graphOut, result, "diff"); err != nil {
+			return err
+		}
+	default:
+		if diffGraphFile == "" {
+			return fmt.Errorf("must provide a graph output file for format %q", format)
+		}
+		var dot bytes.Buffer
+		if err := util.WriteDiffDOTGraph(&dot, result, "diff"); err != nil {
+			return err
+		}
+		if err := renderDOTGraphviz(dot.Bytes(), diffGraphFile, format); err != nil {
+			return err
+		}
+	}
+	return failedStacksError(oldModel, newModel)
+}
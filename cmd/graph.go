@@ -1,31 +1,23 @@
 package cmd
 
 import (
-	"bytes"
 	"context"
 	"flag"
-	"os"
+	"fmt"
+	"io"
 	"path/filepath"
 	"strings"
 
 	"github.com/ankon/explain-cloudformation-changeset/internal/util"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
-	"github.com/aws/smithy-go/logging"
-	"github.com/goccy/go-graphviz"
-	"github.com/goccy/go-graphviz/cgraph"
-	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
-const defaultLayoutName = string(graphviz.DOT)
-
 var graphCmd = &cobra.Command{
 	Use:   "graph",
 	Short: "Render a changeset as a graph",
 	Long:  `This command processes a changeset and represents the changes visually in a directed graph`,
-	Run: func(cmd *cobra.Command, args []string) {
-		graph()
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCommand(cmd, graph)
 	},
 	// See https://github.com/spf13/cobra/issues/943#issuecomment-528655208
 	Version: version,
@@ -33,97 +25,86 @@ var graphCmd = &cobra.Command{
 
 var graphFile string
 var layoutName string
+var graphFormat string
+var allParameters bool
 
 func init() {
-	graphCmd.Flags().StringVarP(&graphFile, "graph-output", "o", "", "File to write changeset graph (should be using .dot/.svg/.png/.jpg extension")
+	graphCmd.Flags().StringVarP(&graphFile, "graph-output", "o", "", "File to write changeset graph (should be using .dot/.svg/.png/.jpg/.html/.mmd extension)")
+	graphCmd.Flags().StringVar(&graphFormat, "format", "", "Graph format, one of dot, mermaid, and when built with Graphviz support xdot, svg, png, jpg, html (default: derived from the graph output file extension)")
 	graphCmd.Flags().StringVarP(&layoutName, "layout", "K", defaultLayoutName, "Graphviz layout engine")
+	graphCmd.Flags().BoolVar(&allParameters, "all-parameters", false, "Show all parameters of each stack, including those that cause no changes")
 
 	rootCmd.AddCommand(graphCmd)
 }
 
-func graph() {
+func graph(ctx context.Context) error {
 
 	if changeSetName == "" {
 		flag.PrintDefaults()
-		log.Fatalf("must provide change set name")
+		return fmt.Errorf("must provide change set name")
 	}
 
-	// Using the SDK's default configuration, loading additional config
-	// and credentials values from the environment variables, shared
-	// credentials, and shared configuration files
-	awsLogger := logging.LoggerFunc(func(classification logging.Classification, format string, v ...interface{}) {
-		log.WithField("process", "s3").Debug(v...)
-	})
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(region),
-		config.WithLogger(awsLogger))
+	svc, err := newClient(ctx)
 	if err != nil {
-		log.Fatalf("unable to load SDK config, %v", err)
+		return err
 	}
 
-	// Using the Config value, create the DynamoDB client
-	svc, err := util.NewClientWithCache(cloudformation.NewFromConfig(cfg), &util.ClientWithCacheOpts{CacheDir: &cacheDir})
-	if err != nil {
-		log.Fatalf("cannot create client, %v", err)
+	format := graphFormatFor(graphFile, graphFormat)
+	if format == "" {
+		// Nothing to render
+		return nil
 	}
 
-	if graphFile != "" {
-		g := graphviz.New()
-		graph, err := g.Graph(
-			graphviz.Directed,
-			graphviz.Name(changeSetName),
-		)
-		if err != nil {
-			log.Fatalf("failed to create new graph, %v", err)
-		}
-		defer func() {
-			if err := graph.Close(); err != nil {
-				// XXX: Can we somehow return this error, rather than panicing?
-				log.Fatal(err)
-			}
-			g.Close()
-		}()
-
-		layout := graphviz.Layout(layoutName)
-		g.SetLayout(layout)
-		if layout == graphviz.SFDP || layout == graphviz.FDP {
-			// See https://gitlab.com/graphviz/graphviz/-/issues/1269, the go-graphviz library
-			// doesn't have triangulation either ("delaunay_tri: Graphviz built without any triangulation library")
-			// XXX: This should accept a string, not a boolean!
-			graph.SetOverlap(true)
-		}
-
-		_, err = util.NewChangeSetGraph(graph, svc, stackName, changeSetName)
-		if err != nil {
-			log.Fatalf("unable to build graph, %v", err)
-		}
+	model, err := loadChangeSetModel(ctx, svc)
+	if err != nil {
+		return err
+	}
 
-		var format graphviz.Format
-		ext := strings.ToLower(filepath.Ext(graphFile))
-		switch ext {
-		case ".png":
-			format = graphviz.PNG
-		case ".jpg":
-			fallthrough
-		case ".jpeg":
-			format = graphviz.JPG
-		case ".svg":
-			format = graphviz.SVG
-		case ".dot":
-			format = graphviz.XDOT
-		default:
-			format = graphviz.PNG
-		}
+	if err := writeGraph(model, graphFile, format); err != nil {
+		return err
+	}
+	return failedStacksError(model)
+}
 
-		graph.SetRankDir(cgraph.LRRank)
+// The explicitly given graph format, or the format derived from the extension of the file name
+func graphFormatFor(fileName string, format string) string {
+	if format != "" {
+		return strings.ToLower(format)
+	}
+	return strings.TrimPrefix(strings.ToLower(filepath.Ext(fileName)), ".")
+}
 
-		var buf bytes.Buffer
-		if err := g.Render(graph, format, &buf); err != nil {
-			log.Fatal(err)
+// Write the graph of the model in the given format, using standard output for text formats if no file is given
+func writeGraph(model *util.ChangeSetModel, fileName string, format string) error {
+	opts := &util.GraphOpts{AllParameters: allParameters}
+	switch format {
+	case "mmd", "mermaid":
+		return writeTextGraph(model, fileName, func(out io.Writer, model *util.ChangeSetModel) error {
+			return util.WriteMermaidGraph(out, model, opts)
+		})
+	case "dot", "gv":
+		// With Graphviz DOT files get laid out, the DOT writer is for builds without it and for standard output
+		if graphvizAvailable && fileName != "" {
+			return renderGraphviz(model, fileName, format, opts)
 		}
-		err = os.WriteFile(graphFile, buf.Bytes(), 0644)
-		if err != nil {
-			log.Fatal(err)
+		return writeTextGraph(model, fileName, func(out io.Writer, model *util.ChangeSetModel) error {
+			return util.WriteDOTGraph(out, model, changeSetName, opts)
+		})
+	default:
+		if fileName == "" {
+			return fmt.Errorf("must provide a graph output file for format %q", format)
 		}
+		return renderGraphviz(model, fileName, format, opts)
 	}
 }
+
+// Write a graph in a text format, using standard output if no file name is given
+func writeTextGraph(model *util.ChangeSetModel, fileName string, write func(io.Writer, *util.ChangeSetModel) error) (err error) {
+	out, err := createOutput(fileName)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out, &err)
+
+	return write(out, model)
+}
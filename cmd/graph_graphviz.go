@@ -0,0 +1,111 @@
+//go:build !nocgo
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/ankon/explain-cloudformation-changeset/internal/util"
+	"github.com/goccy/go-graphviz"
+	"github.com/goccy/go-graphviz/cgraph"
+)
+
+const defaultLayoutName = string(graphviz.DOT)
+
+// Whether the Graphviz library is available for laying out and rendering graphs
+const graphvizAvailable = true
+
+func renderGraphviz(model *util.ChangeSetModel, fileName string, formatName string, opts *util.GraphOpts) (err error) {
+	g := graphviz.New()
+	graph, err := g.Graph(
+		graphviz.Directed,
+		graphviz.Name(changeSetName),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create new graph, %v", err)
+	}
+	defer closeGraph(g, graph, &err)
+
+	layout := graphviz.Layout(layoutName)
+	g.SetLayout(layout)
+	if layout == graphviz.SFDP || layout == graphviz.FDP {
+		// See https://gitlab.com/graphviz/graphviz/-/issues/1269, the go-graphviz library
+		// doesn't have triangulation either ("delaunay_tri: Graphviz built without any triangulation library")
+		// XXX: This should accept a string, not a boolean!
+		graph.SetOverlap(true)
+	}
+
+	_, err = util.NewChangeSetGraph(graph, model, opts)
+	if err != nil {
+		return fmt.Errorf("unable to build graph, %v", err)
+	}
+
+	graph.SetRankDir(cgraph.LRRank)
+
+	var buf bytes.Buffer
+	if err := g.Render(graph, graphvizFormat(formatName), &buf); err != nil {
+		return err
+	}
+	if formatName == "html" {
+		var html bytes.Buffer
+		if err := util.WriteHTMLReport(&html, model, buf.Bytes()); err != nil {
+			return err
+		}
+		buf = html
+	}
+	return os.WriteFile(fileName, buf.Bytes(), 0644)
+}
+
+// Lay out DOT source with Graphviz, and write the result in the given format
+func renderDOTGraphviz(dot []byte, fileName string, formatName string) (err error) {
+	if formatName == "html" {
+		return fmt.Errorf("format %q is not supported for this graph", formatName)
+	}
+
+	graph, err := graphviz.ParseBytes(dot)
+	if err != nil {
+		return fmt.Errorf("failed to parse graph, %v", err)
+	}
+	g := graphviz.New()
+	defer closeGraph(g, graph, &err)
+	g.SetLayout(graphviz.Layout(layoutName))
+
+	var buf bytes.Buffer
+	if err := g.Render(graph, graphvizFormat(formatName), &buf); err != nil {
+		return err
+	}
+	return os.WriteFile(fileName, buf.Bytes(), 0644)
+}
+
+// Close the graph and Graphviz, and report the error of closing the graph unless there already is an error
+func closeGraph(g *graphviz.Graphviz, graph *cgraph.Graph, err *error) {
+	if closeErr := graph.Close(); closeErr != nil && *err == nil {
+		*err = closeErr
+	}
+	g.Close()
+}
+
+func graphvizFormat(formatName string) graphviz.Format {
+	var format graphviz.Format
+	switch formatName {
+	case "png":
+		format = graphviz.PNG
+	case "jpg":
+		fallthrough
+	case "jpeg":
+		format = graphviz.JPG
+	case "svg":
+		format = graphviz.SVG
+	case "dot", "gv", "xdot":
+		// Laid out DOT source
+		format = graphviz.XDOT
+	case "html":
+		// Rendered as SVG and then embedded into the page
+		format = graphviz.SVG
+	default:
+		format = graphviz.PNG
+	}
+	return format
+}
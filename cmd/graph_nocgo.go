@@ -0,0 +1,22 @@
+//go:build nocgo
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/ankon/explain-cloudformation-changeset/internal/util"
+)
+
+const defaultLayoutName = "dot"
+
+// Whether the Graphviz library is available for laying out and rendering graphs
+const graphvizAvailable = false
+
+func renderGraphviz(model *util.ChangeSetModel, fileName string, formatName string, opts *util.GraphOpts) error {
+	return fmt.Errorf("format %q needs Graphviz, which is not available in this build (use dot or mermaid instead)", formatName)
+}
+
+func renderDOTGraphviz(dot []byte, fileName string, formatName string) error {
+	return fmt.Errorf("format %q needs Graphviz, which is not available in this build (use dot instead)", formatName)
+}
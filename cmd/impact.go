@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/spf13/cobra"
+)
+
+var impactCmd = &cobra.Command{
+	Use:   "impact",
+	Short: "List the changes caused by a parameter or resource",
+	Long: `This command follows the cause edges from a parameter or resource, and lists all changes that it causes directly and transitively.
+
+Changes can cross into nested stacks through their parameters, and out of nested stacks through their outputs. The changeset does not say which parameters and outputs of a nested stack are involved, so a change of a nested stack affects all of its parameters that cause changes, and every change inside a nested stack affects all of its outputs that cause changes in the parent stack. The result can therefore contain more changes than will actually happen.
+
+The depth of a change counts the cause edges from the origin, and passing the parameters into a nested stack as one more step.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCommand(cmd, impact)
+	},
+	Version: version,
+}
+
+var impactFrom string
+var impactGraphFile string
+var impactGraphFormat string
+
+func init() {
+	impactCmd.Flags().StringVar(&impactFrom, "from", "", "Parameter (StackName.ParameterKey) or resource (StackName.LogicalResourceId) to start from")
+	impactCmd.Flags().StringVarP(&impactGraphFile, "graph-output", "o", "", "File to write the graph restricted to the affected changes")
+	impactCmd.Flags().StringVar(&impactGraphFormat, "format", "", "Graph format, see the graph command (default: derived from the graph output file extension)")
+	impactCmd.MarkFlagRequired("from")
+
+	rootCmd.AddCommand(impactCmd)
+}
+
+func impact(ctx context.Context) error {
+	if changeSetName == "" {
+		flag.PrintDefaults()
+		return fmt.Errorf("must provide change set name")
+	}
+
+	svc, err := newClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	model, err := loadChangeSetModel(ctx, svc)
+	if err != nil {
+		return err
+	}
+
+	result, err := model.Impact(impactFrom)
+	if err != nil {
+		return err
+	}
+
+	// Keep standard output for the graph when it is written there
+	format := graphFormatFor(impactGraphFile, impactGraphFormat)
+	var out io.Writer = os.Stdout
+	if format != "" && impactGraphFile == "" {
+		out = os.Stderr
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Depth\tId\tResourceType\tAction\tReplacement\tVia\n")
+	for _, change := range result.Changes {
+		rc := change.Resource.ResourceChange()
+		via := ""
+		if change.Via != nil {
+			via = fmt.Sprintf("%s -> %s", change.Via.SourceId(), change.Via.TargetPath())
+		}
+		row := []string{
+			fmt.Sprint(change.Depth),
+			change.Resource.Id(),
+			aws.ToString(rc.ResourceType),
+			string(rc.Action),
+			string(rc.Replacement),
+			via,
+		}
+		fmt.Fprintf(w, "%s\n", strings.Join(row, "\t"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if format != "" {
+		if err := writeGraph(model.Subset(result.Resources(), result.Causes), impactGraphFile, format); err != nil {
+			return err
+		}
+	}
+	return failedStacksError(model)
+}
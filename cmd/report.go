@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ankon/explain-cloudformation-changeset/internal/util"
+	"github.com/spf13/cobra"
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Write a review report for a changeset",
+	Long:  `This command processes a changeset and its nested changesets, and writes a report suitable for reviewing the changes, for example in a pull request comment`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCommand(cmd, report)
+	},
+	Version: version,
+}
+
+var reportFile string
+var reportFormat string
+
+func init() {
+	reportCmd.Flags().StringVarP(&reportFile, "report-output", "o", "", "File to write the report to (default: standard output)")
+	reportCmd.Flags().StringVar(&reportFormat, "format", "markdown", "Report format (markdown, json)")
+
+	rootCmd.AddCommand(reportCmd)
+}
+
+func report(ctx context.Context) (err error) {
+	if changeSetName == "" {
+		flag.PrintDefaults()
+		return fmt.Errorf("must provide change set name")
+	}
+
+	format := strings.ToLower(reportFormat)
+	var writeReport func(io.Writer, *util.ChangeSetModel) error
+	switch format {
+	case "markdown", "md":
+		writeReport = util.WriteMarkdownReport
+	case "json":
+		writeReport = util.WriteJSONReport
+	default:
+		return fmt.Errorf("unsupported report format %q", reportFormat)
+	}
+
+	svc, err := newClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	model, err := loadChangeSetModel(ctx, svc)
+	if err != nil {
+		return err
+	}
+
+	out, err := createOutput(reportFile)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out, &err)
+
+	if err := writeReport(out, model); err != nil {
+		return err
+	}
+	return failedStacksError(model)
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ankon/explain-cloudformation-changeset/internal/util"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var risksCmd = &cobra.Command{
+	Use:   "risks",
+	Short: "List the risks of a changeset",
+	Long:  `This command rates all resource changes of a changeset and its nested changesets, and lists them with the most dangerous changes first`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCommand(cmd, risks)
+	},
+	Version: version,
+}
+
+var risksFile string
+var risksFormat string
+var risksMinLevel string
+
+func init() {
+	risksCmd.Flags().StringVarP(&risksFile, "risks-output", "o", "", "File to write the risks to (default: standard output)")
+	risksCmd.Flags().StringVar(&risksFormat, "format", "text", "Output format (text, csv, json)")
+	risksCmd.Flags().StringVar(&risksMinLevel, "min-level", "low", "Only list risks with at least this level (low, medium, high, critical)")
+
+	rootCmd.AddCommand(risksCmd)
+}
+
+var risksHeader = []string{
+	"Level",
+	"StackName",
+	"LogicalResourceId",
+	"ResourceType",
+	"Action",
+	"Replacement",
+	"Reasons",
+}
+
+type jsonRisk struct {
+	Level             string   `json:"level"`
+	Id                string   `json:"id"`
+	StackName         string   `json:"stackName"`
+	LogicalResourceId string   `json:"logicalResourceId"`
+	ResourceType      string   `json:"resourceType,omitempty"`
+	Action            string   `json:"action,omitempty"`
+	Replacement       string   `json:"replacement,omitempty"`
+	Reasons           []string `json:"reasons"`
+}
+
+func riskRow(risk *util.Risk) []string {
+	rc := risk.Resource.ResourceChange()
+	return []string{
+		risk.Level.String(),
+		risk.Resource.Stack.Name,
+		risk.Resource.LogicalResourceId,
+		aws.ToString(rc.ResourceType),
+		string(rc.Action),
+		string(rc.Replacement),
+		strings.Join(risk.Reasons, "; "),
+	}
+}
+
+func writeRisksText(out io.Writer, risks []*util.Risk) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	io.WriteString(w, strings.Join(risksHeader, "\t")+"\n")
+	for _, risk := range risks {
+		io.WriteString(w, strings.Join(riskRow(risk), "\t")+"\n")
+	}
+	return w.Flush()
+}
+
+func writeRisksCSV(out io.Writer, risks []*util.Risk) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(risksHeader); err != nil {
+		return err
+	}
+	for _, risk := range risks {
+		if err := w.Write(riskRow(risk)); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func writeRisksJSON(out io.Writer, risks []*util.Risk) error {
+	result := []*jsonRisk{}
+	for _, risk := range risks {
+		rc := risk.Resource.ResourceChange()
+		reasons := risk.Reasons
+		if reasons == nil {
+			reasons = []string{}
+		}
+		result = append(result, &jsonRisk{
+			Level:             risk.Level.String(),
+			Id:                risk.Resource.Id(),
+			StackName:         risk.Resource.Stack.Name,
+			LogicalResourceId: risk.Resource.LogicalResourceId,
+			ResourceType:      aws.ToString(rc.ResourceType),
+			Action:            string(rc.Action),
+			Replacement:       string(rc.Replacement),
+			Reasons:           reasons,
+		})
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+func risks(ctx context.Context) (err error) {
+	if changeSetName == "" {
+		flag.PrintDefaults()
+		return fmt.Errorf("must provide change set name")
+	}
+
+	var writeRisks func(io.Writer, []*util.Risk) error
+	switch strings.ToLower(risksFormat) {
+	case "text":
+		writeRisks = writeRisksText
+	case "csv":
+		writeRisks = writeRisksCSV
+	case "json":
+		writeRisks = writeRisksJSON
+	default:
+		return fmt.Errorf("unsupported risks format %q", risksFormat)
+	}
+	minLevel, err := util.ParseRiskLevel(risksMinLevel)
+	if err != nil {
+		return err
+	}
+
+	if !changeFilter.IsEmpty() {
+		log.Warnf("only listing the risks of changes that pass the filters (%s)", changeFilterFlags)
+	}
+
+	svc, err := newClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	model, err := loadChangeSetModel(ctx, svc)
+	if err != nil {
+		return err
+	}
+
+	selected := []*util.Risk{}
+	for _, risk := range model.Risks() {
+		if risk.Level >= minLevel {
+			selected = append(selected, risk)
+		}
+	}
+
+	out, err := createOutput(risksFile)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out, &err)
+
+	if err := writeRisks(out, selected); err != nil {
+		return err
+	}
+	return failedStacksError(model)
+}
@@ -1,9 +1,21 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/ankon/explain-cloudformation-changeset/internal/util"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/smithy-go/logging"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -17,12 +29,24 @@ var rootCmd = &cobra.Command{
 	Long:    `explain-cloudformation-changeset provides tools to make reviewing a CloudFormation changeset easier`,
 	Aliases: []string{graphCmd.Name()},
 	Version: version,
+	// Execute reports the errors, and decides on the exit code
+	SilenceErrors: true,
 }
 
 var cacheDir string
 var region string
 var stackName string
 var changeSetName string
+var concurrency int
+var keepGoing bool
+var templates bool
+var currentStacks bool
+var checkDrift bool
+var detectDrift bool
+var endpointURL string
+var stackPolicies bool
+var changeFilter util.ChangeFilter
+var timeout time.Duration
 
 func checkRootAlias(a string, b []string) {
 	for _, v := range b {
@@ -58,12 +82,58 @@ func Execute() {
 		firstArg = os.Args[1]
 	}
 	checkRootAlias(firstArg, nonRootSubCmds())
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+
+	// Cancel outstanding requests on Ctrl-C, a second signal terminates immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	err := rootCmd.ExecuteContext(ctx)
+	stop()
+	if err != nil {
+		log.Error(err)
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
 		os.Exit(1)
 	}
 }
 
+// An error that exits with a specific code, rather than the generic failure exit code 1
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitCodeError) Unwrap() error {
+	return e.err
+}
+
+// Run a command with its context
+//
+// All errors are returned to Execute, so that deferred closes run before exiting.
+func runCommand(cmd *cobra.Command, run func(ctx context.Context) error) error {
+	// Errors from here on are not about the usage
+	cmd.SilenceUsage = true
+	ctx, cancel := commandContext(cmd)
+	defer cancel()
+	return run(ctx)
+}
+
+// The context for running a command, limited by the timeout if one is given
+func commandContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(cmd.Context(), timeout)
+	}
+	return context.WithCancel(cmd.Context())
+}
+
 func getEnvOrDefault(defaultValue string, names ...string) string {
 	for _, name := range names {
 		val, present := os.LookupEnv(name)
@@ -75,6 +145,108 @@ func getEnvOrDefault(defaultValue string, names ...string) string {
 	return defaultValue
 }
 
+func newClient(ctx context.Context) (*util.ClientWithCache, error) {
+	return newClientWithCacheDir(ctx, cacheDir)
+}
+
+func newClientWithCacheDir(ctx context.Context, cacheDir string) (*util.ClientWithCache, error) {
+	// Using the SDK's default configuration, loading additional config
+	// and credentials values from the environment variables, shared
+	// credentials, and shared configuration files
+	awsLogger := logging.LoggerFunc(func(classification logging.Classification, format string, v ...interface{}) {
+		log.WithField("process", "s3").Debug(v...)
+	})
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion(region),
+		config.WithLogger(awsLogger))
+	if err != nil {
+		return nil, fmt.Errorf("unable to load SDK config, %v", err)
+	}
+
+	// Using the Config value, create the DynamoDB client
+	client := cloudformation.NewFromConfig(cfg, func(o *cloudformation.Options) {
+		// Allow using a local stand-in for CloudFormation
+		if endpointURL != "" {
+			o.BaseEndpoint = aws.String(endpointURL)
+		}
+	})
+	svc, err := util.NewClientWithCache(client, &util.ClientWithCacheOpts{CacheDir: &cacheDir})
+	if err != nil {
+		return nil, fmt.Errorf("cannot create client, %v", err)
+	}
+
+	return svc, nil
+}
+
+// Fetch the changeset including all nested changesets, and interpret it
+func loadChangeSetModel(ctx context.Context, svc *util.ClientWithCache) (*util.ChangeSetModel, error) {
+	return loadNamedChangeSetModel(ctx, svc, changeSetName)
+}
+
+func loadNamedChangeSetModel(ctx context.Context, svc *util.ClientWithCache, changeSetName string) (*util.ChangeSetModel, error) {
+	if err := changeFilter.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid filter, %v", err)
+	}
+
+	tree, err := util.FetchChangeSetTree(ctx, svc, stackName, changeSetName, &util.FetchChangeSetTreeOpts{Concurrency: concurrency, KeepGoing: keepGoing, Templates: templates, CurrentStacks: currentStacks, CheckDrift: checkDrift, DetectDrift: detectDrift, StackPolicies: stackPolicies})
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch changeset, %v", err)
+	}
+
+	model, err := util.NewChangeSetModel(tree)
+	if err != nil {
+		return nil, fmt.Errorf("unable to interpret changeset, %v", err)
+	}
+	if !changeFilter.IsEmpty() {
+		model = model.Filter(&changeFilter)
+	}
+	return model, nil
+}
+
+// The filter flags, for messages
+const changeFilterFlags = "--action, --replacement, --resource-type, --stack, --exclude-scope"
+
+// An error listing all stacks that could not be processed, nil if all stacks were processed
+//
+// Commands return this after writing their outputs, so that the outputs are complete.
+func failedStacksError(models ...*util.ChangeSetModel) error {
+	failed := []string{}
+	for _, model := range models {
+		for _, stack := range model.FailedStacks() {
+			failed = append(failed, fmt.Sprintf("  %s: %v", stack.Id(), stack.Err))
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d stack(s) could not be processed:\n%s", len(failed), strings.Join(failed, "\n"))
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// Create the named output file, or use standard output if no name is given
+func createOutput(fileName string) (io.WriteCloser, error) {
+	if fileName == "" {
+		return nopWriteCloser{os.Stdout}, nil
+	}
+	return os.Create(fileName)
+}
+
+// Close the output, and report the error of closing unless there already is an error
+//
+// This is meant to be deferred by functions with a named error result.
+func closeOutput(out io.Closer, err *error) {
+	if closeErr := out.Close(); closeErr != nil && *err == nil {
+		*err = closeErr
+	}
+}
+
 func init() {
 	cwd, err := os.Getwd()
 	if err != nil {
@@ -87,5 +259,19 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&region, "region", getEnvOrDefault("us-east-1", "AWS_REGION", "AWS_DEFAULT_REGION"), "AWS region")
 	rootCmd.PersistentFlags().StringVar(&stackName, "stack-name", "", "Root stack name (required when change set is not given as ARN)")
 	rootCmd.PersistentFlags().StringVar(&changeSetName, "change-set-name", "", "Root change set name")
+	rootCmd.PersistentFlags().IntVar(&concurrency, "concurrency", 4, "Maximum number of concurrent requests when fetching nested changesets")
+	rootCmd.PersistentFlags().BoolVar(&keepGoing, "keep-going", false, "Continue when nested stacks cannot be processed, and mark them as failed in the output")
+	rootCmd.PersistentFlags().BoolVar(&templates, "templates", false, "Fetch the templates of the changesets and stacks to show the values of changed properties")
+	rootCmd.PersistentFlags().BoolVar(&currentStacks, "current-stacks", false, "Fetch the currently deployed stacks to show how parameter values change")
+	rootCmd.PersistentFlags().BoolVar(&checkDrift, "check-drift", false, "Fetch the drift results of the deployed stacks to flag drifted resources that get changed")
+	rootCmd.PersistentFlags().BoolVar(&detectDrift, "detect-drift", false, "Detect the drift of the deployed stacks and wait for the results (implies --check-drift)")
+	rootCmd.PersistentFlags().BoolVar(&stackPolicies, "stack-policies", false, "Fetch the stack policies of the deployed stacks to predict which changes they will block")
+	rootCmd.PersistentFlags().StringVar(&endpointURL, "endpoint-url", "", "CloudFormation endpoint URL, for example of a local stand-in (default: the regional AWS endpoint)")
+	rootCmd.PersistentFlags().StringSliceVar(&changeFilter.Actions, "action", nil, "Only show changes with these actions (Add, Modify, Remove, Import, Dynamic)")
+	rootCmd.PersistentFlags().StringSliceVar(&changeFilter.Replacement, "replacement", nil, "Only show changes with these replacement values (True, False, Conditional)")
+	rootCmd.PersistentFlags().StringSliceVar(&changeFilter.ResourceTypes, "resource-type", nil, "Only show changes of resources with types matching these glob patterns, for example AWS::RDS::*")
+	rootCmd.PersistentFlags().StringSliceVar(&changeFilter.Stacks, "stack", nil, "Only show changes in stacks with names matching these glob patterns")
+	rootCmd.PersistentFlags().StringSliceVar(&changeFilter.ExcludeScopes, "exclude-scope", nil, "Leave out changes that only touch these scopes, for example Tags")
+	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", 0, "Maximum time for fetching changesets, for example 2m (default: no limit)")
 	rootCmd.MarkPersistentFlagRequired("change-set-name")
 }
@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ankon/explain-cloudformation-changeset/internal/util"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/spf13/cobra"
+)
+
+var tableCmd = &cobra.Command{
+	Use:   "table",
+	Short: "List all changes of a changeset in a table",
+	Long:  `This command processes a changeset and its nested changesets, and writes one row per planned resource change as CSV or TSV`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runCommand(cmd, table)
+	},
+	Version: version,
+}
+
+var tableFile string
+var tableFormat string
+
+// Columns that are always written
+var tableHeader = []string{
+	"StackName",
+	"LogicalResourceId",
+	"PhysicalResourceId",
+	"ResourceType",
+	"Action",
+	"Replacement",
+	"Scope",
+	"Causes",
+	"Hooks",
+	"PropertyChanges",
+	"Fate",
+}
+
+// The columns of the table, the Drift and StackPolicy columns only when the information is fetched
+func tableColumns() []string {
+	columns := append([]string{}, tableHeader...)
+	if checkDrift || detectDrift {
+		columns = append(columns, "Drift")
+	}
+	if stackPolicies {
+		columns = append(columns, "StackPolicy")
+	}
+	return columns
+}
+
+func init() {
+	tableCmd.Flags().StringVarP(&tableFile, "table-output", "o", "", "File to write the table to (default: standard output)")
+	tableCmd.Flags().StringVar(&tableFormat, "format", "", "Table format, csv or tsv (default: derived from the output file extension, csv otherwise)")
+
+	rootCmd.AddCommand(tableCmd)
+}
+
+func table(ctx context.Context) (err error) {
+	if changeSetName == "" {
+		flag.PrintDefaults()
+		return fmt.Errorf("must provide change set name")
+	}
+
+	format := strings.ToLower(tableFormat)
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(tableFile)), ".")
+	}
+	var comma rune
+	switch format {
+	case "tsv":
+		comma = '\t'
+	case "csv", "":
+		comma = ','
+	default:
+		return fmt.Errorf("unsupported table format %q", format)
+	}
+
+	svc, err := newClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	model, err := loadChangeSetModel(ctx, svc)
+	if err != nil {
+		return err
+	}
+
+	out, err := createOutput(tableFile)
+	if err != nil {
+		return err
+	}
+	defer closeOutput(out, &err)
+
+	w := csv.NewWriter(out)
+	w.Comma = comma
+	if err := w.Write(tableColumns()); err != nil {
+		return err
+	}
+	err = model.Root.Walk(func(stack *util.Stack) error {
+		for _, r := range stack.Changes() {
+			rc := r.ResourceChange()
+
+			scope := make([]string, 0, len(rc.Scope))
+			for _, s := range rc.Scope {
+				scope = append(scope, string(s))
+			}
+			propertyChanges := []string{}
+			for _, c := range r.PropertyChanges() {
+				propertyChanges = append(propertyChanges, c.String())
+			}
+			causes := make([]string, 0, len(rc.Details))
+			for _, detail := range rc.Details {
+				causes = append(causes, util.DescribeChangeDetail(detail))
+			}
+
+			row := []string{
+				stack.Name,
+				aws.ToString(rc.LogicalResourceId),
+				aws.ToString(rc.PhysicalResourceId),
+				aws.ToString(rc.ResourceType),
+				string(rc.Action),
+				string(rc.Replacement),
+				strings.Join(scope, ","),
+				strings.Join(causes, "; "),
+				strings.Join(r.HookDescriptions(), "; "),
+				strings.Join(propertyChanges, "; "),
+				r.FateDescription(),
+			}
+			if checkDrift || detectDrift {
+				drift := ""
+				if r.Drift != nil {
+					drift = strings.Join(append([]string{string(r.Drift.StackResourceDriftStatus)}, r.DriftDescriptions()...), "; ")
+				}
+				row = append(row, drift)
+			}
+			if stackPolicies {
+				stackPolicy := ""
+				if verdict, reason := r.StackPolicyVerdict(); verdict != "" {
+					stackPolicy = fmt.Sprintf("%s: %s", verdict, reason)
+				}
+				row = append(row, stackPolicy)
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	return failedStacksError(model)
+}
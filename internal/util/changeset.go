@@ -0,0 +1,530 @@
+package util
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	log "github.com/sirupsen/logrus"
+)
+
+type cloudformationClient interface {
+	cloudformation.DescribeChangeSetAPIClient
+	DescribeChangeSetHooks(ctx context.Context, params *cloudformation.DescribeChangeSetHooksInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeChangeSetHooksOutput, error)
+	GetTemplate(ctx context.Context, params *cloudformation.GetTemplateInput, optFns ...func(*cloudformation.Options)) (*cloudformation.GetTemplateOutput, error)
+	cloudformation.DescribeStacksAPIClient
+	cloudformation.DescribeStackResourceDriftsAPIClient
+	DetectStackDrift(ctx context.Context, params *cloudformation.DetectStackDriftInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DetectStackDriftOutput, error)
+	DescribeStackDriftDetectionStatus(ctx context.Context, params *cloudformation.DescribeStackDriftDetectionStatusInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeStackDriftDetectionStatusOutput, error)
+	GetStackPolicy(ctx context.Context, params *cloudformation.GetStackPolicyInput, optFns ...func(*cloudformation.Options)) (*cloudformation.GetStackPolicyOutput, error)
+}
+
+// How long to wait between checks of the status of a drift detection
+var driftDetectionPollInterval = 5 * time.Second
+
+func contains[E comparable](s []E, e E) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
+
+// A changeset together with the changesets of all its nested stacks
+type ChangeSetTree struct {
+	// Name of the stack this changeset applies to
+	StackName string
+	// Logical id of the stack resource in the parent stack, empty for the root
+	LogicalResourceId string
+
+	// The changeset, nil if there is no changeset for a nested stack
+	ChangeSet *cloudformation.DescribeChangeSetOutput
+	// The error when fetching the changeset failed
+	Err error
+	// The hooks that will be invoked for the changes, only fetched when the changeset announces hook invocations
+	Hooks []types.ChangeSetHook
+	// The processed template of the changeset, and the current template of the stack, only fetched when requested
+	TemplateBody        string
+	CurrentTemplateBody string
+	// The currently deployed stack, only fetched when requested
+	CurrentStack *types.Stack
+	// The drifted resources of the deployed stack, only fetched when requested
+	Drifts []types.StackResourceDrift
+	// The stack policy of the deployed stack, empty if the stack has none; only fetched when requested
+	StackPolicyBody string
+
+	// Nested stacks, in the order of the changes in the changeset
+	Nested []*ChangeSetTree
+}
+
+func isNestedStackChange(change types.Change) bool {
+	return change.ResourceChange != nil && aws.ToString(change.ResourceChange.ResourceType) == "AWS::CloudFormation::Stack"
+}
+
+// Parse the stack name out of a stack ARN
+func stackNameFromArn(stackArn string) (string, error) {
+	a, err := arn.Parse(stackArn)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(a.Resource, "/")
+	if len(parts) < 2 || parts[0] != "stack" {
+		return "", fmt.Errorf("ARN %q is not referencing a CloudFormation stack", stackArn)
+	}
+	return parts[1], nil
+}
+
+type FetchChangeSetTreeOpts struct {
+	// Maximum number of concurrent DescribeChangeSet requests, defaults to 1
+	Concurrency int
+	// Record failures for nested stacks in the tree instead of failing
+	KeepGoing bool
+	// Fetch the templates of the changesets and the stacks
+	Templates bool
+	// Fetch the currently deployed stacks
+	CurrentStacks bool
+	// Fetch the drift results of the deployed stacks
+	CheckDrift bool
+	// Detect the drift of the deployed stacks before fetching the drift results, implies `CheckDrift`
+	DetectDrift bool
+	// Fetch the stack policies of the deployed stacks
+	StackPolicies bool
+}
+
+type changeSetTreeFetcher struct {
+	svc           cloudformationClient
+	keepGoing     bool
+	templates     bool
+	currentStacks bool
+	checkDrift    bool
+	detectDrift   bool
+	stackPolicies bool
+
+	// Semaphore limiting the number of concurrent requests
+	requests chan struct{}
+	wg       sync.WaitGroup
+
+	mu  sync.Mutex
+	err error
+	// Stops the outstanding requests after the first failure that is not recorded in the tree
+	cancel context.CancelFunc
+}
+
+func (f *changeSetTreeFetcher) fail(ctx context.Context, nested *ChangeSetTree, err error) {
+	// Cancellation always stops the whole fetch, there is no point in trying the remaining stacks
+	if f.keepGoing && ctx.Err() == nil {
+		log.Warnf("%v", err)
+		nested.Err = err
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err == nil {
+		f.err = err
+		f.cancel()
+	}
+}
+
+func (f *changeSetTreeFetcher) describeChangeSet(ctx context.Context, params *cloudformation.DescribeChangeSetInput) (*cloudformation.DescribeChangeSetOutput, error) {
+	select {
+	case f.requests <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-f.requests }()
+	return f.svc.DescribeChangeSet(ctx, params)
+}
+
+// Whether any change of the changeset will invoke hooks
+func hasHookInvocations(changeSet *cloudformation.DescribeChangeSetOutput) bool {
+	for _, change := range changeSet.Changes {
+		if aws.ToInt32(change.HookInvocationCount) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Fetch the hooks of the changeset of the tree
+//
+// Hooks are additional information, so failures are only logged.
+func (f *changeSetTreeFetcher) fetchHooks(ctx context.Context, tree *ChangeSetTree) {
+	defer f.wg.Done()
+
+	select {
+	case f.requests <- struct{}{}:
+	case <-ctx.Done():
+		return
+	}
+	defer func() { <-f.requests }()
+
+	log.Infof("fetching hooks of stack %v", tree.StackName)
+	hooks, err := f.svc.DescribeChangeSetHooks(ctx, &cloudformation.DescribeChangeSetHooksInput{
+		ChangeSetName: tree.ChangeSet.ChangeSetId,
+	})
+	if err != nil {
+		log.Warnf("failed to get hooks for stack %s, %v", tree.StackName, err)
+		return
+	}
+	tree.Hooks = hooks.Hooks
+}
+
+func (f *changeSetTreeFetcher) getTemplate(ctx context.Context, params *cloudformation.GetTemplateInput) (string, error) {
+	select {
+	case f.requests <- struct{}{}:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	defer func() { <-f.requests }()
+
+	template, err := f.svc.GetTemplate(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(template.TemplateBody), nil
+}
+
+// Fetch the processed template of the changeset of the tree, and the current template of the stack if the stack exists
+//
+// Templates are additional information, so failures are only logged.
+func (f *changeSetTreeFetcher) fetchTemplates(ctx context.Context, tree *ChangeSetTree, stackExists bool) {
+	defer f.wg.Done()
+
+	log.Infof("fetching templates of stack %v", tree.StackName)
+	body, err := f.getTemplate(ctx, &cloudformation.GetTemplateInput{
+		ChangeSetName: tree.ChangeSet.ChangeSetId,
+		TemplateStage: types.TemplateStageProcessed,
+	})
+	if err != nil {
+		log.Warnf("failed to get template of changeset for stack %s, %v", tree.StackName, err)
+	}
+	tree.TemplateBody = body
+
+	if !stackExists {
+		return
+	}
+	body, err = f.getTemplate(ctx, &cloudformation.GetTemplateInput{
+		StackName:     tree.ChangeSet.StackId,
+		TemplateStage: types.TemplateStageProcessed,
+	})
+	if err != nil {
+		log.Warnf("failed to get current template of stack %s, %v", tree.StackName, err)
+	}
+	tree.CurrentTemplateBody = body
+}
+
+// Fetch the currently deployed stack of the tree
+//
+// The stack is additional information, so failures are only logged.
+func (f *changeSetTreeFetcher) fetchCurrentStack(ctx context.Context, tree *ChangeSetTree) {
+	defer f.wg.Done()
+
+	select {
+	case f.requests <- struct{}{}:
+	case <-ctx.Done():
+		return
+	}
+	defer func() { <-f.requests }()
+
+	log.Infof("fetching current state of stack %v", tree.StackName)
+	stacks, err := f.svc.DescribeStacks(ctx, &cloudformation.DescribeStacksInput{
+		StackName: tree.ChangeSet.StackId,
+	})
+	if err != nil {
+		log.Warnf("failed to get current state of stack %s, %v", tree.StackName, err)
+		return
+	}
+	if len(stacks.Stacks) != 1 {
+		log.Warnf("unexpected number of stacks (%d) for stack %s", len(stacks.Stacks), tree.StackName)
+		return
+	}
+	tree.CurrentStack = &stacks.Stacks[0]
+}
+
+// Run a request once the number of concurrent requests allows it
+func (f *changeSetTreeFetcher) withRequestSlot(ctx context.Context, fn func() error) error {
+	select {
+	case f.requests <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-f.requests }()
+	return fn()
+}
+
+// Detect the drift of the stack of the tree, and wait for the detection to finish
+//
+// Detections can take a while, so requests are only counted while they are actually sent.
+func (f *changeSetTreeFetcher) detectStackDrift(ctx context.Context, tree *ChangeSetTree) error {
+	var detection *cloudformation.DetectStackDriftOutput
+	err := f.withRequestSlot(ctx, func() (err error) {
+		detection, err = f.svc.DetectStackDrift(ctx, &cloudformation.DetectStackDriftInput{
+			StackName: tree.ChangeSet.StackId,
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-time.After(driftDetectionPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		var status *cloudformation.DescribeStackDriftDetectionStatusOutput
+		err := f.withRequestSlot(ctx, func() (err error) {
+			status, err = f.svc.DescribeStackDriftDetectionStatus(ctx, &cloudformation.DescribeStackDriftDetectionStatusInput{
+				StackDriftDetectionId: detection.StackDriftDetectionId,
+			})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		switch status.DetectionStatus {
+		case types.StackDriftDetectionStatusDetectionInProgress:
+			continue
+		case types.StackDriftDetectionStatusDetectionFailed:
+			// The results for the resources that could be checked are still available
+			log.Warnf("drift detection for stack %s failed, %s", tree.StackName, aws.ToString(status.DetectionStatusReason))
+		}
+		return nil
+	}
+}
+
+// Fetch the drifted resources of the stack of the tree, detecting the drift first if requested
+//
+// Drift results are additional information, so failures are only logged.
+func (f *changeSetTreeFetcher) fetchDrifts(ctx context.Context, tree *ChangeSetTree) {
+	defer f.wg.Done()
+
+	if f.detectDrift {
+		log.Infof("detecting drift of stack %v", tree.StackName)
+		if err := f.detectStackDrift(ctx, tree); err != nil {
+			log.Warnf("failed to detect drift of stack %s, %v", tree.StackName, err)
+			return
+		}
+	}
+
+	log.Infof("fetching drift of stack %v", tree.StackName)
+	var drifts *cloudformation.DescribeStackResourceDriftsOutput
+	err := f.withRequestSlot(ctx, func() (err error) {
+		drifts, err = f.svc.DescribeStackResourceDrifts(ctx, &cloudformation.DescribeStackResourceDriftsInput{
+			StackName: tree.ChangeSet.StackId,
+			StackResourceDriftStatusFilters: []types.StackResourceDriftStatus{
+				types.StackResourceDriftStatusModified,
+				types.StackResourceDriftStatusDeleted,
+			},
+		})
+		return err
+	})
+	if err != nil {
+		log.Warnf("failed to get drift of stack %s, %v", tree.StackName, err)
+		return
+	}
+	tree.Drifts = drifts.StackResourceDrifts
+}
+
+// Fetch the stack policy of the stack of the tree
+//
+// The stack policy is additional information, so failures are only logged.
+func (f *changeSetTreeFetcher) fetchStackPolicy(ctx context.Context, tree *ChangeSetTree) {
+	defer f.wg.Done()
+
+	log.Infof("fetching stack policy of stack %v", tree.StackName)
+	var policy *cloudformation.GetStackPolicyOutput
+	err := f.withRequestSlot(ctx, func() (err error) {
+		policy, err = f.svc.GetStackPolicy(ctx, &cloudformation.GetStackPolicyInput{
+			StackName: tree.ChangeSet.StackId,
+		})
+		return err
+	})
+	if err != nil {
+		log.Warnf("failed to get stack policy of stack %s, %v", tree.StackName, err)
+		return
+	}
+	tree.StackPolicyBody = aws.ToString(policy.StackPolicyBody)
+}
+
+// Start fetching the additional information about the stack of the tree
+//
+// The state of the deployed stack is cached for the changeset of the tree.
+func (f *changeSetTreeFetcher) startStackDetails(ctx context.Context, tree *ChangeSetTree, stackExists bool) {
+	ctx = ContextForChangeSet(ctx, aws.ToString(tree.ChangeSet.ChangeSetId))
+	if f.templates {
+		f.wg.Add(1)
+		go f.fetchTemplates(ctx, tree, stackExists)
+	}
+	if f.currentStacks && stackExists {
+		f.wg.Add(1)
+		go f.fetchCurrentStack(ctx, tree)
+	}
+	if f.checkDrift && stackExists {
+		f.wg.Add(1)
+		go f.fetchDrifts(ctx, tree)
+	}
+	if f.stackPolicies && stackExists {
+		f.wg.Add(1)
+		go f.fetchStackPolicy(ctx, tree)
+	}
+}
+
+func (f *changeSetTreeFetcher) fetchNested(ctx context.Context, parent *ChangeSetTree, nested *ChangeSetTree, change *types.ResourceChange) {
+	defer f.wg.Done()
+
+	log.Infof("fetching %q nested stack %v.%v", change.Action, parent.StackName, nested.LogicalResourceId)
+
+	// Query the change set of that stack, which will also reveal the actual stack name
+	nestedChangeSet, err := f.describeChangeSet(ctx, &cloudformation.DescribeChangeSetInput{
+		ChangeSetName:         change.ChangeSetId,
+		IncludePropertyValues: aws.Bool(true),
+	})
+	if err != nil {
+		// Try to at least find out the name of the stack
+		if nestedStackName, err := stackNameFromArn(aws.ToString(change.PhysicalResourceId)); err == nil {
+			nested.StackName = nestedStackName
+		} else {
+			nested.StackName = makeNodeId(parent.StackName, nested.LogicalResourceId)
+		}
+		f.fail(ctx, nested, fmt.Errorf("failed to get changeset for nested stack %s.%s, %v", parent.StackName, nested.LogicalResourceId, err))
+		return
+	}
+	nested.ChangeSet = nestedChangeSet
+	nested.StackName = aws.ToString(nestedChangeSet.StackName)
+
+	f.startStackDetails(ctx, nested, change.Action != types.ChangeActionAdd)
+	f.startNested(ctx, nested)
+}
+
+// Prepare the nested trees of the parent, and start fetching their changesets
+func (f *changeSetTreeFetcher) startNested(ctx context.Context, parent *ChangeSetTree) {
+	if hasHookInvocations(parent.ChangeSet) {
+		f.wg.Add(1)
+		go f.fetchHooks(ctx, parent)
+	}
+
+	for _, change := range parent.ChangeSet.Changes {
+		if !isNestedStackChange(change) {
+			continue
+		}
+
+		// Add the nested tree right away, so that the order matches the order of the changes
+		nested := &ChangeSetTree{LogicalResourceId: aws.ToString(change.ResourceChange.LogicalResourceId)}
+		parent.Nested = append(parent.Nested, nested)
+
+		if change.ResourceChange.ChangeSetId != nil {
+			f.wg.Add(1)
+			go f.fetchNested(ctx, parent, nested, change.ResourceChange)
+		} else {
+			nestedStackName, err := stackNameFromArn(aws.ToString(change.ResourceChange.PhysicalResourceId))
+			if err != nil {
+				// Odd?
+				nested.StackName = makeNodeId(parent.StackName, nested.LogicalResourceId)
+				f.fail(ctx, nested, fmt.Errorf("failed to parse physical resource id of nested stack %s.%s as ARN, %v", parent.StackName, nested.LogicalResourceId, err))
+				continue
+			}
+			nested.StackName = nestedStackName
+		}
+	}
+}
+
+// Fetch the changeset and recursively all changesets of nested stacks
+//
+// Nested changesets are fetched concurrently, the resulting tree keeps the order of the changes in the
+// changesets. With `KeepGoing` failures for nested stacks are recorded in the tree, and only a failure
+// to fetch the root changeset returns an error. The hooks of a changeset are only fetched when its changes
+// announce hook invocations, the templates only with `Templates`, the deployed stacks only with
+// `CurrentStacks`, the drifted resources only with `CheckDrift` or `DetectDrift`, and the stack policies only
+// with `StackPolicies`. Cancelling the context stops all outstanding requests, and so does
+// a failure without `KeepGoing`.
+func FetchChangeSetTree(ctx context.Context, svc cloudformationClient, stackName string, rootChangeSetName string, opts *FetchChangeSetTreeOpts) (*ChangeSetTree, error) {
+	concurrency := 1
+	if opts != nil && opts.Concurrency > 0 {
+		concurrency = opts.Concurrency
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	f := &changeSetTreeFetcher{
+		svc:           svc,
+		keepGoing:     opts != nil && opts.KeepGoing,
+		templates:     opts != nil && opts.Templates,
+		currentStacks: opts != nil && opts.CurrentStacks,
+		checkDrift:    opts != nil && (opts.CheckDrift || opts.DetectDrift),
+		detectDrift:   opts != nil && opts.DetectDrift,
+		stackPolicies: opts != nil && opts.StackPolicies,
+		requests:      make(chan struct{}, concurrency),
+		cancel:        cancel,
+	}
+
+	params := &cloudformation.DescribeChangeSetInput{
+		ChangeSetName:         aws.String(rootChangeSetName),
+		IncludePropertyValues: aws.Bool(true),
+	}
+	if stackName != "" {
+		params.StackName = aws.String(stackName)
+	}
+	resp, err := f.describeChangeSet(ctx, params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get changeset, %v", err)
+	}
+
+	root := &ChangeSetTree{
+		StackName: aws.ToString(resp.StackName),
+		ChangeSet: resp,
+	}
+	f.startStackDetails(ctx, root, true)
+	f.startNested(ctx, root)
+	f.wg.Wait()
+	if f.err != nil {
+		return nil, f.err
+	}
+	return root, nil
+}
+
+// Visit this tree and all nested trees depth-first, parents before their nested stacks
+func (t *ChangeSetTree) Walk(fn func(*ChangeSetTree) error) error {
+	if err := fn(t); err != nil {
+		return err
+	}
+	for _, nested := range t.Nested {
+		if err := nested.Walk(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Describe a single change detail in a compact human-readable form
+//
+// The result looks like "ParameterReference Purpose (Static) -> Properties.Tags".
+func DescribeChangeDetail(detail types.ResourceChangeDetail) string {
+	var sb strings.Builder
+	sb.WriteString(string(detail.ChangeSource))
+	if causingEntity := aws.ToString(detail.CausingEntity); causingEntity != "" {
+		sb.WriteString(" ")
+		sb.WriteString(causingEntity)
+	}
+	if detail.Evaluation != "" {
+		fmt.Fprintf(&sb, " (%s)", detail.Evaluation)
+	}
+	if detail.Target != nil {
+		sb.WriteString(" -> ")
+		sb.WriteString(string(detail.Target.Attribute))
+		if name := aws.ToString(detail.Target.Name); name != "" {
+			sb.WriteString(".")
+			sb.WriteString(name)
+		}
+	}
+	return sb.String()
+}
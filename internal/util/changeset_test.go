@@ -0,0 +1,80 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// A client with a root changeset containing a nested stack whose changeset cannot be fetched, and one whose
+// changeset is only returned when the request is not cancelled
+type failingNestedClient struct {
+	cloudformationClient
+
+	// Whether the request for the slow changeset was cancelled, empty if there was no request
+	cancelled chan bool
+}
+
+func nestedStackChange(logicalResourceId string) types.Change {
+	change := testChange(logicalResourceId, "AWS::CloudFormation::Stack", types.ChangeActionModify, types.ReplacementFalse)
+	change.ResourceChange.ChangeSetId = aws.String(logicalResourceId + "-cs")
+	return change
+}
+
+func (c *failingNestedClient) DescribeChangeSet(ctx context.Context, params *cloudformation.DescribeChangeSetInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeChangeSetOutput, error) {
+	switch aws.ToString(params.ChangeSetName) {
+	case "Root-cs":
+		return &cloudformation.DescribeChangeSetOutput{
+			StackName: aws.String("Root"),
+			Changes:   []types.Change{nestedStackChange("Slow"), nestedStackChange("Broken")},
+		}, nil
+	case "Slow-cs":
+		select {
+		case <-ctx.Done():
+			c.cancelled <- true
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			c.cancelled <- false
+			return &cloudformation.DescribeChangeSetOutput{StackName: aws.String("Slow")}, nil
+		}
+	}
+	return nil, errors.New("access denied")
+}
+
+func TestFetchChangeSetTreeCancelsAfterFailure(t *testing.T) {
+	tests := []struct {
+		name          string
+		keepGoing     bool
+		wantCancelled bool
+	}{
+		{"stop at the first failure", false, true},
+		{"keep going", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &failingNestedClient{cancelled: make(chan bool, 1)}
+			tree, err := FetchChangeSetTree(context.Background(), svc, "", "Root-cs", &FetchChangeSetTreeOpts{Concurrency: 2, KeepGoing: tt.keepGoing})
+			// The slow changeset is not requested at all when the failure comes first
+			cancelled := true
+			select {
+			case cancelled = <-svc.cancelled:
+			default:
+			}
+			if cancelled != tt.wantCancelled {
+				t.Errorf("request for the slow changeset cancelled = %v, want %v", cancelled, tt.wantCancelled)
+			}
+			if tt.keepGoing {
+				if err != nil || tree.Nested[1].Err == nil {
+					t.Errorf("FetchChangeSetTree() = %v, want the failure recorded in the tree", err)
+				}
+			} else if err == nil {
+				t.Errorf("FetchChangeSetTree() succeeded, want an error")
+			}
+		})
+	}
+}
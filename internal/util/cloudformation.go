@@ -5,11 +5,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	log "github.com/sirupsen/logrus"
 )
 
 type ClientWithCacheOpts struct {
@@ -20,12 +24,24 @@ type ClientWithCache struct {
 	*cloudformation.Client
 
 	cacheDir string
+
+	// Requests currently in flight, indexed by the name of the cache file
+	mu       sync.Mutex
+	inflight map[string]*inflightDescribeChangeSet
+}
+
+type inflightDescribeChangeSet struct {
+	done   chan struct{}
+	result *cloudformation.DescribeChangeSetOutput
+	err    error
 }
 
 // Create a new "cached" CloudFormation client
 //
-// The returned client will persistently store results of `DescribeChangeSet` in the specified
-// `CacheDir` (if unset: the current directory)
+// The returned client will persistently store results of `DescribeChangeSet`, `DescribeChangeSetHooks`,
+// `GetTemplate`, `DescribeStacks`, `DescribeStackResourceDrifts` and `GetStackPolicy` in the specified `CacheDir`
+// (if unset: the current directory). Information about deployed stacks is cached per changeset, see
+// `ContextForChangeSet`. Concurrent requests for the same changeset are only sent once.
 func NewClientWithCache(svc *cloudformation.Client, opts *ClientWithCacheOpts) (*ClientWithCache, error) {
 	var cacheDir string
 	if opts == nil || opts.CacheDir == nil || *opts.CacheDir == "" {
@@ -43,49 +59,327 @@ func NewClientWithCache(svc *cloudformation.Client, opts *ClientWithCacheOpts) (
 		return nil, fmt.Errorf("cannot make cache directory %q, %v", cacheDir, err)
 	}
 
-	return &ClientWithCache{svc, cacheDir}, nil
+	return &ClientWithCache{
+		Client:   svc,
+		cacheDir: cacheDir,
+		inflight: map[string]*inflightDescribeChangeSet{},
+	}, nil
 }
 
-func (c *ClientWithCache) DescribeChangeSet(ctx context.Context, params *cloudformation.DescribeChangeSetInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeChangeSetOutput, error) {
-	// TODO: Check whether we already have that particular changeset cached locally
-	var cachedName string
-	changeSetName := aws.ToString(params.ChangeSetName)
+// The name of the cache file for a changeset given by name or ARN, the suffix distinguishes other information
+// about the changeset
+func (c *ClientWithCache) cacheFileName(changeSetName string, suffix string) (string, error) {
 	if arn.IsARN(changeSetName) {
 		// Full ARN, the name is the part after changeSet
 		changeSetArn, err := arn.Parse(changeSetName)
 		if err != nil {
-			return nil, err
+			return "", err
 		}
 
 		parts := strings.Split(changeSetArn.Resource, "/")
 		if changeSetArn.Service != "cloudformation" || parts[0] != "changeSet" {
-			return nil, fmt.Errorf("ARN %q is not referencing a CloudFormation changeset", changeSetName)
+			return "", fmt.Errorf("ARN %q is not referencing a CloudFormation changeset", changeSetName)
 		}
-		cachedName = fmt.Sprintf("%s/%s.json", c.cacheDir, parts[1])
-	} else {
-		cachedName = fmt.Sprintf("%s/%s.json", c.cacheDir, changeSetName)
+		changeSetName = parts[1]
 	}
+	return fmt.Sprintf("%s/%s%s.json", c.cacheDir, changeSetName, suffix), nil
+}
 
-	cached, err := os.ReadFile(cachedName)
-	if err == nil {
-		result := &cloudformation.DescribeChangeSetOutput{}
-		if json.Unmarshal(cached, result) == nil {
-			return result, nil
-		}
-		// else: Query again
+type changeSetContextKey struct{}
+
+// A context for requests about the deployed stack of a changeset, so that their results get cached for the changeset
+//
+// Stacks change over time while changesets don't, so the state of a stack is only cached per changeset: caching it by
+// the stack name would reuse outdated state when reviewing a later changeset of the same stack.
+func ContextForChangeSet(ctx context.Context, changeSetName string) context.Context {
+	return context.WithValue(ctx, changeSetContextKey{}, changeSetName)
+}
+
+// The name of the cache file for information about the deployed stack, empty if the state must not be cached
+// because the context has no changeset
+func (c *ClientWithCache) stackCacheFileName(ctx context.Context, suffix string) (string, error) {
+	changeSetName, _ := ctx.Value(changeSetContextKey{}).(string)
+	if changeSetName == "" {
+		return "", nil
+	}
+	return c.cacheFileName(changeSetName, suffix)
+}
+
+// The name of the cache file for the template of a changeset, or when no changeset is given of the stack
+func (c *ClientWithCache) templateCacheFileName(ctx context.Context, params *cloudformation.GetTemplateInput) (string, error) {
+	stage := params.TemplateStage
+	if stage == "" {
+		stage = types.TemplateStageOriginal
 	}
-	result, err := c.Client.DescribeChangeSet(ctx, params)
+	if params.ChangeSetName != nil {
+		return c.cacheFileName(aws.ToString(params.ChangeSetName), fmt.Sprintf(".template-%s", stage))
+	}
+	return c.stackCacheFileName(ctx, fmt.Sprintf(".stack-template-%s", stage))
+}
+
+// Read a cached result, returns false if there is no usable cached result or the result is not cached at all
+func readCache(cachedName string, result interface{}) bool {
+	if cachedName == "" {
+		return false
+	}
+	cached, err := os.ReadFile(cachedName)
 	if err != nil {
-		return nil, err
+		return false
 	}
+	return json.Unmarshal(cached, result) == nil
+}
 
+// Save a result in the cache, unless the result is not cached at all
+func writeCache(cachedName string, result interface{}) {
+	if cachedName == "" {
+		return
+	}
 	data, err := json.Marshal(result)
 	if err == nil {
 		// Marshalling worked, try to save the contents. If it didn't, there's no problem
 		// and we just might get called again
-		os.WriteFile(cachedName, data, 0644)
+		if err := writeFileAtomically(cachedName, data, 0644); err != nil {
+			log.Warnf("cannot write cache file %q, %v", cachedName, err)
+		}
+	}
+}
+
+func (c *ClientWithCache) DescribeChangeSet(ctx context.Context, params *cloudformation.DescribeChangeSetInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeChangeSetOutput, error) {
+	cachedName, err := c.cacheFileName(aws.ToString(params.ChangeSetName), "")
+	if err != nil {
+		return nil, err
+	}
+
+	// Wait for a request for the same changeset that is already in flight
+	c.mu.Lock()
+	call, present := c.inflight[cachedName]
+	if present {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.result, call.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	call = &inflightDescribeChangeSet{done: make(chan struct{})}
+	c.inflight[cachedName] = call
+	c.mu.Unlock()
+
+	call.result, call.err = c.describeChangeSet(ctx, cachedName, params, optFns...)
+
+	c.mu.Lock()
+	delete(c.inflight, cachedName)
+	c.mu.Unlock()
+	close(call.done)
+
+	return call.result, call.err
+}
+
+// Whether the changeset modifies properties without reporting any of their values, as changesets described without
+// IncludePropertyValues do
+func lacksPropertyValues(changeSet *cloudformation.DescribeChangeSetOutput) bool {
+	modifiesProperties := false
+	for _, change := range changeSet.Changes {
+		if change.ResourceChange == nil {
+			continue
+		}
+		for _, detail := range change.ResourceChange.Details {
+			target := detail.Target
+			if target == nil || target.Attribute != types.ResourceAttributeProperties {
+				continue
+			}
+			if target.BeforeValue != nil || target.AfterValue != nil {
+				return false
+			}
+			modifiesProperties = true
+		}
+	}
+	return modifiesProperties
+}
+
+func (c *ClientWithCache) describeChangeSet(ctx context.Context, cachedName string, params *cloudformation.DescribeChangeSetInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeChangeSetOutput, error) {
+	result := &cloudformation.DescribeChangeSetOutput{}
+	if readCache(cachedName, result) {
+		if aws.ToBool(params.IncludePropertyValues) && lacksPropertyValues(result) {
+			log.Infof("cached changeset %q has no property values, remove %q to fetch it again with them, or use --templates", aws.ToString(params.ChangeSetName), cachedName)
+		}
+		return result, nil
+	}
+	// else: Query again
+	result, err := c.Client.DescribeChangeSet(ctx, params, optFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	writeCache(cachedName, result)
+	return result, nil
+}
+
+// Describe the hooks of a changeset, stored in the cache next to the changeset
+//
+// All pages are fetched, and the result contains the hooks of all pages.
+func (c *ClientWithCache) DescribeChangeSetHooks(ctx context.Context, params *cloudformation.DescribeChangeSetHooksInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeChangeSetHooksOutput, error) {
+	cachedName, err := c.cacheFileName(aws.ToString(params.ChangeSetName), ".hooks")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &cloudformation.DescribeChangeSetHooksOutput{}
+	if readCache(cachedName, result) {
+		return result, nil
 	}
 
-	// Write. If it fails, bad luck.
+	hooks := []types.ChangeSetHook{}
+	pageParams := *params
+	for {
+		result, err = c.Client.DescribeChangeSetHooks(ctx, &pageParams, optFns...)
+		if err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, result.Hooks...)
+		if result.NextToken == nil {
+			break
+		}
+		pageParams.NextToken = result.NextToken
+	}
+	result.Hooks = hooks
+
+	writeCache(cachedName, result)
 	return result, nil
 }
+
+// Get the template of a changeset or of a stack, stored in the cache next to the changeset
+func (c *ClientWithCache) GetTemplate(ctx context.Context, params *cloudformation.GetTemplateInput, optFns ...func(*cloudformation.Options)) (*cloudformation.GetTemplateOutput, error) {
+	cachedName, err := c.templateCacheFileName(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &cloudformation.GetTemplateOutput{}
+	if readCache(cachedName, result) {
+		return result, nil
+	}
+	result, err = c.Client.GetTemplate(ctx, params, optFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	writeCache(cachedName, result)
+	return result, nil
+}
+
+// Describe a single stack, stored in the cache next to the changeset
+//
+// Only requests for a single stack given by `StackName` are supported.
+func (c *ClientWithCache) DescribeStacks(ctx context.Context, params *cloudformation.DescribeStacksInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeStacksOutput, error) {
+	cachedName, err := c.stackCacheFileName(ctx, ".stack")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &cloudformation.DescribeStacksOutput{}
+	if readCache(cachedName, result) {
+		return result, nil
+	}
+	result, err = c.Client.DescribeStacks(ctx, params, optFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	writeCache(cachedName, result)
+	return result, nil
+}
+
+// Get the stack policy of a stack, stored in the cache next to the changeset
+func (c *ClientWithCache) GetStackPolicy(ctx context.Context, params *cloudformation.GetStackPolicyInput, optFns ...func(*cloudformation.Options)) (*cloudformation.GetStackPolicyOutput, error) {
+	cachedName, err := c.stackCacheFileName(ctx, ".stack-policy")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &cloudformation.GetStackPolicyOutput{}
+	if readCache(cachedName, result) {
+		return result, nil
+	}
+	result, err = c.Client.GetStackPolicy(ctx, params, optFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	writeCache(cachedName, result)
+	return result, nil
+}
+
+// Describe the drift of the resources of a stack, stored in the cache next to the changeset
+//
+// All pages are fetched, and the result contains the drifts of all pages.
+func (c *ClientWithCache) DescribeStackResourceDrifts(ctx context.Context, params *cloudformation.DescribeStackResourceDriftsInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DescribeStackResourceDriftsOutput, error) {
+	cachedName, err := c.stackCacheFileName(ctx, ".drifts")
+	if err != nil {
+		return nil, err
+	}
+
+	result := &cloudformation.DescribeStackResourceDriftsOutput{}
+	if readCache(cachedName, result) {
+		return result, nil
+	}
+
+	drifts := []types.StackResourceDrift{}
+	pageParams := *params
+	for {
+		result, err = c.Client.DescribeStackResourceDrifts(ctx, &pageParams, optFns...)
+		if err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, result.StackResourceDrifts...)
+		if result.NextToken == nil {
+			break
+		}
+		pageParams.NextToken = result.NextToken
+	}
+	result.StackResourceDrifts = drifts
+
+	writeCache(cachedName, result)
+	return result, nil
+}
+
+// Start a drift detection for a stack
+//
+// The cached drift results of the stack are outdated by the detection, and get removed.
+func (c *ClientWithCache) DetectStackDrift(ctx context.Context, params *cloudformation.DetectStackDriftInput, optFns ...func(*cloudformation.Options)) (*cloudformation.DetectStackDriftOutput, error) {
+	cachedName, err := c.stackCacheFileName(ctx, ".drifts")
+	if err != nil {
+		return nil, err
+	}
+	if cachedName == "" {
+		return c.Client.DetectStackDrift(ctx, params, optFns...)
+	}
+	if err := os.Remove(cachedName); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot remove cached drifts %q, %v", cachedName, err)
+	}
+	return c.Client.DetectStackDrift(ctx, params, optFns...)
+}
+
+// Write the file through a temporary file in the same directory, so that an interrupted write never
+// leaves a partial file behind
+func writeFileAtomically(name string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
@@ -0,0 +1,38 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func TestLacksPropertyValues(t *testing.T) {
+	withValues := testParameterDetail("InstanceType", "InstanceType")
+	withValues.Target.BeforeValue = aws.String("t3.micro")
+	withValues.Target.AfterValue = aws.String("t3.small")
+	tags := types.ResourceChangeDetail{
+		ChangeSource: types.ChangeSourceDirectModification,
+		Target:       &types.ResourceTargetDefinition{Attribute: types.ResourceAttributeTags},
+	}
+
+	tests := []struct {
+		name    string
+		changes []types.Change
+		want    bool
+	}{
+		{"no changes", nil, false},
+		{"only additions", []types.Change{testChange("Topic", "AWS::SNS::Topic", types.ChangeActionAdd, "")}, false},
+		{"only tags", []types.Change{testChange("Topic", "AWS::SNS::Topic", types.ChangeActionModify, types.ReplacementFalse, tags)}, false},
+		{"property values", []types.Change{testChange("Instance", "AWS::EC2::Instance", types.ChangeActionModify, types.ReplacementTrue, withValues)}, false},
+		{"property names only", []types.Change{testChange("Instance", "AWS::EC2::Instance", types.ChangeActionModify, types.ReplacementTrue, testParameterDetail("InstanceType", "InstanceType"))}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lacksPropertyValues(&cloudformation.DescribeChangeSetOutput{Changes: tt.changes}); got != tt.want {
+				t.Errorf("lacksPropertyValues() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,412 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// How an entity differs between two changesets
+type DiffStatus string
+
+const (
+	DiffAdded     DiffStatus = "added"
+	DiffRemoved   DiffStatus = "removed"
+	DiffChanged   DiffStatus = "changed"
+	DiffUnchanged DiffStatus = "unchanged"
+)
+
+// A changed resource in one or both changesets
+type ResourceDiff struct {
+	// The logical path of the resource, see `Resource.LogicalPath`
+	Path string
+	// The resource in the old and the new changeset, nil if the resource does not change in that changeset
+	Old    *Resource
+	New    *Resource
+	Status DiffStatus
+}
+
+// A cause edge in one or both changesets
+type CauseDiff struct {
+	// A key identifying the cause by logical paths, see `causeDiffKey`
+	Key    string
+	Old    *Cause
+	New    *Cause
+	Status DiffStatus
+}
+
+// The differences between two changesets
+//
+// Nested stacks are matched by the logical ids of their stack resources, not by their generated names or the ids
+// of their changesets.
+type ChangeSetDiff struct {
+	Resources []*ResourceDiff
+	Causes    []*CauseDiff
+}
+
+// The logical path of the entity causing the change
+func causeSourcePath(c *Cause) string {
+	switch {
+	case c.Parameter != nil:
+		return c.Parameter.LogicalPath()
+	case c.Resource != nil && c.SourceAttribute != "":
+		return makeNodeId(c.Resource.LogicalPath(), c.SourceAttribute)
+	case c.Resource != nil:
+		return c.Resource.LogicalPath()
+	}
+	return makeLogicalPath(c.Changed.Stack.LogicalPath(), string(c.Source))
+}
+
+// A key identifying the cause independently of generated stack names, "ChangedPath:TargetPath<-SourcePath (Evaluation)"
+func causeDiffKey(c *Cause) string {
+	return fmt.Sprintf("%s:%s<-%s (%s)", c.Changed.LogicalPath(), c.TargetPath(), causeSourcePath(c), c.Evaluation)
+}
+
+func resourceChangesDiffer(old *Resource, new *Resource) bool {
+	oldChange, newChange := old.ResourceChange(), new.ResourceChange()
+	if oldChange == nil || newChange == nil {
+		return oldChange != newChange
+	}
+	return oldChange.Action != newChange.Action ||
+		oldChange.Replacement != newChange.Replacement ||
+		aws.ToString(oldChange.ResourceType) != aws.ToString(newChange.ResourceType)
+}
+
+func changedResourcesByPath(model *ChangeSetModel) ([]string, map[string]*Resource) {
+	paths := []string{}
+	resources := map[string]*Resource{}
+	model.Root.Walk(func(stack *Stack) error {
+		for _, r := range stack.Changes() {
+			path := r.LogicalPath()
+			paths = append(paths, path)
+			resources[path] = r
+		}
+		return nil
+	})
+	return paths, resources
+}
+
+func causesByKey(model *ChangeSetModel) ([]string, map[string]*Cause) {
+	keys := []string{}
+	causes := map[string]*Cause{}
+	model.Root.Walk(func(stack *Stack) error {
+		for _, cause := range stack.Causes {
+			key := causeDiffKey(cause)
+			if _, present := causes[key]; !present {
+				keys = append(keys, key)
+				causes[key] = cause
+			}
+		}
+		return nil
+	})
+	return keys, causes
+}
+
+// Compare two changesets
+//
+// The result contains all changed resources and all cause edges of both changesets, in the order of the old
+// changeset followed by the entities only found in the new changeset.
+func DiffChangeSetModels(old *ChangeSetModel, new *ChangeSetModel) *ChangeSetDiff {
+	diff := &ChangeSetDiff{Resources: []*ResourceDiff{}, Causes: []*CauseDiff{}}
+
+	oldPaths, oldResources := changedResourcesByPath(old)
+	newPaths, newResources := changedResourcesByPath(new)
+	for _, path := range oldPaths {
+		rd := &ResourceDiff{Path: path, Old: oldResources[path], New: newResources[path]}
+		switch {
+		case rd.New == nil:
+			rd.Status = DiffRemoved
+		case resourceChangesDiffer(rd.Old, rd.New):
+			rd.Status = DiffChanged
+		default:
+			rd.Status = DiffUnchanged
+		}
+		diff.Resources = append(diff.Resources, rd)
+	}
+	for _, path := range newPaths {
+		if _, present := oldResources[path]; !present {
+			diff.Resources = append(diff.Resources, &ResourceDiff{Path: path, New: newResources[path], Status: DiffAdded})
+		}
+	}
+
+	oldKeys, oldCauses := causesByKey(old)
+	newKeys, newCauses := causesByKey(new)
+	for _, key := range oldKeys {
+		cd := &CauseDiff{Key: key, Old: oldCauses[key], New: newCauses[key], Status: DiffUnchanged}
+		if cd.New == nil {
+			cd.Status = DiffRemoved
+		}
+		diff.Causes = append(diff.Causes, cd)
+	}
+	for _, key := range newKeys {
+		if _, present := oldCauses[key]; !present {
+			diff.Causes = append(diff.Causes, &CauseDiff{Key: key, New: newCauses[key], Status: DiffAdded})
+		}
+	}
+
+	return diff
+}
+
+// Whether the two changesets differ at all
+func (diff *ChangeSetDiff) HasDifferences() bool {
+	for _, rd := range diff.Resources {
+		if rd.Status != DiffUnchanged {
+			return true
+		}
+	}
+	for _, cd := range diff.Causes {
+		if cd.Status != DiffUnchanged {
+			return true
+		}
+	}
+	return false
+}
+
+// The resource in the new changeset, or in the old one for removed resources
+func (rd *ResourceDiff) Resource() *Resource {
+	if rd.New != nil {
+		return rd.New
+	}
+	return rd.Old
+}
+
+// The cause in the new changeset, or in the old one for removed causes
+func (cd *CauseDiff) Cause() *Cause {
+	if cd.New != nil {
+		return cd.New
+	}
+	return cd.Old
+}
+
+// A short description of the change of a resource, "Modify (Replacement: True)"
+func describeResourceChange(r *Resource) string {
+	rc := r.ResourceChange()
+	if rc == nil {
+		return string(r.Change.Type)
+	}
+	if rc.Replacement == "" {
+		return string(rc.Action)
+	}
+	return fmt.Sprintf("%s (Replacement: %s)", rc.Action, rc.Replacement)
+}
+
+// Write the differences as text, one line per difference
+func WriteDiffText(out io.Writer, diff *ChangeSetDiff, oldName string, newName string) error {
+	w := bufio.NewWriter(out)
+
+	if !diff.HasDifferences() {
+		fmt.Fprintf(w, "No differences between %s and %s\n", oldName, newName)
+		return w.Flush()
+	}
+
+	sections := []struct {
+		title  string
+		prefix string
+		status DiffStatus
+	}{
+		{fmt.Sprintf("Resources only changed in %s", oldName), "-", DiffRemoved},
+		{fmt.Sprintf("Resources only changed in %s", newName), "+", DiffAdded},
+		{"Resources with a different change", "~", DiffChanged},
+	}
+	for _, section := range sections {
+		lines := []string{}
+		for _, rd := range diff.Resources {
+			if rd.Status != section.status {
+				continue
+			}
+			r := rd.Resource()
+			description := fmt.Sprintf("%s %s (%s): %s", section.prefix, rd.Path, r.ResourceType(), describeResourceChange(r))
+			if rd.Status == DiffChanged {
+				description = fmt.Sprintf("%s %s (%s): %s -> %s", section.prefix, rd.Path, r.ResourceType(), describeResourceChange(rd.Old), describeResourceChange(rd.New))
+			}
+			lines = append(lines, description)
+		}
+		writeDiffSection(w, section.title, lines)
+	}
+
+	causeSections := []struct {
+		title  string
+		prefix string
+		status DiffStatus
+	}{
+		{fmt.Sprintf("Causes only in %s", oldName), "-", DiffRemoved},
+		{fmt.Sprintf("Causes only in %s", newName), "+", DiffAdded},
+	}
+	for _, section := range causeSections {
+		lines := []string{}
+		for _, cd := range diff.Causes {
+			if cd.Status == section.status {
+				lines = append(lines, fmt.Sprintf("%s %s", section.prefix, cd.Key))
+			}
+		}
+		writeDiffSection(w, section.title, lines)
+	}
+
+	return w.Flush()
+}
+
+func writeDiffSection(w io.Writer, title string, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%s:\n", title)
+	for _, line := range lines {
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+	fmt.Fprintf(w, "\n")
+}
+
+func diffStatusColor(status DiffStatus) color {
+	switch status {
+	case DiffAdded:
+		return diffAddedColor
+	case DiffRemoved:
+		return diffRemovedColor
+	case DiffChanged:
+		return diffChangedColor
+	}
+	return diffUnchangedColor
+}
+
+func diffStatusAttributes(status DiffStatus) []dotAttribute {
+	attributes := []dotAttribute{{"color", diffStatusColor(status)}}
+	switch status {
+	case DiffAdded, DiffChanged:
+		attributes = append(attributes, dotAttribute{"penwidth", "2"})
+	case DiffRemoved:
+		attributes = append(attributes, dotAttribute{"style", "dashed"}, dotAttribute{"penwidth", "2"})
+	case DiffUnchanged:
+		attributes = append(attributes, dotAttribute{"fontcolor", diffUnchangedColor})
+	}
+	return attributes
+}
+
+// The node for the entity causing the change, resources are used without their attribute
+//
+// Logical ids are alphanumeric, so the nodes for parameters and direct modifications are placed below a stack node
+// name component to keep them apart from the resources.
+func diffSourceNodeId(c *Cause) string {
+	stackPath := c.Changed.Stack.LogicalPath()
+	switch {
+	case c.Resource != nil:
+		return c.Resource.LogicalPath()
+	case c.Parameter != nil:
+		return makeLogicalPath(stackPath, makeNodeId(stackNodeName, makeNodeId(parametersNodeName, c.Parameter.Name)))
+	}
+	return makeLogicalPath(stackPath, makeNodeId(stackNodeName, string(c.Source)))
+}
+
+type diffGraphNode struct {
+	id         string
+	attributes []dotAttribute
+}
+
+type diffGraph struct {
+	// Nodes grouped by the logical path of their stack
+	nodes map[string][]diffGraphNode
+	// Logical paths of the nested stacks of each stack, in the order they were added
+	nested map[string][]string
+	seen   map[string]bool
+}
+
+// Add the stack and the stacks containing it, so that the clusters are nested like the stacks
+func (g *diffGraph) addStack(stack *Stack) {
+	stackPath := stack.LogicalPath()
+	if _, present := g.nodes[stackPath]; present {
+		return
+	}
+	g.nodes[stackPath] = []diffGraphNode{}
+	if stack.Parent != nil {
+		g.addStack(stack.Parent)
+		parentPath := stack.Parent.LogicalPath()
+		g.nested[parentPath] = append(g.nested[parentPath], stackPath)
+	}
+}
+
+func (g *diffGraph) addNode(stack *Stack, id string, attributes ...dotAttribute) {
+	if g.seen[id] {
+		return
+	}
+	g.seen[id] = true
+	g.addStack(stack)
+	stackPath := stack.LogicalPath()
+	g.nodes[stackPath] = append(g.nodes[stackPath], diffGraphNode{id, attributes})
+}
+
+// Write the nodes of the stack, and the clusters of its nested stacks
+func (g *diffGraph) writeStack(w *dotWriter, indent string, stackPath string) {
+	for _, node := range g.nodes[stackPath] {
+		w.writeNode(indent, node.id, node.attributes...)
+	}
+	for _, nestedPath := range g.nested[stackPath] {
+		fmt.Fprintf(w, "%ssubgraph %s {\n", indent, dotQuote(dotClusterName(nestedPath)))
+		fmt.Fprintf(w, "%s\tlabel=%s;\n", indent, dotQuote(nestedPath))
+		g.writeStack(w, indent+"\t", nestedPath)
+		fmt.Fprintf(w, "%s}\n", indent)
+	}
+}
+
+// Write the union of both changesets as DOT source, with the differences highlighted
+//
+// Resources and cause edges only in the old changeset are dashed, the ones only in the new changeset are bold,
+// and resources with a different change show both changes. Everything that is the same in both is gray.
+func WriteDiffDOTGraph(out io.Writer, diff *ChangeSetDiff, name string) error {
+	w := &dotWriter{Writer: bufio.NewWriter(out)}
+	g := &diffGraph{nodes: map[string][]diffGraphNode{}, nested: map[string][]string{}, seen: map[string]bool{}}
+
+	for _, rd := range diff.Resources {
+		r := rd.Resource()
+		label := []string{
+			fmt.Sprintf("%s %s", changeActionPrefix(r.ResourceChange().Action), r.LogicalResourceId),
+			r.ResourceType(),
+		}
+		if rd.Status == DiffChanged {
+			label = append(label, fmt.Sprintf("%s -> %s", describeResourceChange(rd.Old), describeResourceChange(rd.New)))
+		} else {
+			label = append(label, describeResourceChange(r))
+		}
+		attributes := append([]dotAttribute{
+			{"shape", "box"},
+			{"label", strings.Join(label, "\n")},
+		}, diffStatusAttributes(rd.Status)...)
+		g.addNode(r.Stack, rd.Path, attributes...)
+	}
+
+	// Entities that only cause changes
+	for _, cd := range diff.Causes {
+		cause := cd.Cause()
+		stack := cause.Changed.Stack
+		switch {
+		case cause.Parameter != nil:
+			g.addNode(stack, diffSourceNodeId(cause), dotAttribute{"label", cause.Parameter.Name}, dotAttribute{"color", usedParameterColor})
+		case cause.Resource != nil:
+			g.addNode(stack, diffSourceNodeId(cause), dotAttribute{"shape", "box"}, dotAttribute{"label", cause.Resource.LogicalResourceId}, dotAttribute{"style", "dotted"})
+		default:
+			g.addNode(stack, diffSourceNodeId(cause), dotAttribute{"shape", "none"}, dotAttribute{"label", "Direct modification"})
+		}
+	}
+
+	fmt.Fprintf(w, "digraph %s {\n", dotQuote(name))
+	fmt.Fprintf(w, "\trankdir=LR;\n")
+	g.writeStack(w, "\t", "")
+	for _, cd := range diff.Causes {
+		cause := cd.Cause()
+		fmt.Fprintf(w, "\t%s -> %s", dotQuote(diffSourceNodeId(cause)), dotQuote(cause.Changed.LogicalPath()))
+		attributes := append([]dotAttribute{
+			{"headlabel", causeTargetLabel(cause)},
+			{"taillabel", cause.SourceAttribute},
+		}, diffStatusAttributes(cd.Status)...)
+		if cause.Evaluation == types.EvaluationTypeDynamic && cd.Status != DiffRemoved {
+			attributes = append(attributes, dotAttribute{"style", "dotted"})
+		}
+		w.writeAttributes(attributes)
+		fmt.Fprintf(w, ";\n")
+	}
+	fmt.Fprintf(w, "}\n")
+
+	return w.Flush()
+}
@@ -0,0 +1,146 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// A root stack with a database and a nested network stack, in two versions
+//
+// The nested stack gets a different generated name, and with that a different changeset id, in each version.
+func testDiffModels(t *testing.T) (*ChangeSetModel, *ChangeSetModel) {
+	old := testTree("Root",
+		testChange("Database", "AWS::RDS::DBInstance", types.ChangeActionModify, types.ReplacementConditional),
+		testChange("Queue", "AWS::SQS::Queue", types.ChangeActionRemove, ""),
+	)
+	testNestedTree(old, "Network", "Root-Network-AAAA",
+		testChange("Vpc", "AWS::EC2::VPC", types.ChangeActionModify, types.ReplacementFalse, testParameterDetail("Cidr", "CidrBlock")),
+		testChange("Subnet", "AWS::EC2::Subnet", types.ChangeActionModify, types.ReplacementFalse, testResourceDetail("Vpc", "VpcId")),
+	)
+
+	new := testTree("Root",
+		testChange("Database", "AWS::RDS::DBInstance", types.ChangeActionModify, types.ReplacementConditional),
+		testChange("Topic", "AWS::SNS::Topic", types.ChangeActionAdd, ""),
+	)
+	testNestedTree(new, "Network", "Root-Network-BBBB",
+		testChange("Vpc", "AWS::EC2::VPC", types.ChangeActionModify, types.ReplacementTrue, testParameterDetail("Cidr", "CidrBlock")),
+		testChange("Subnet", "AWS::EC2::Subnet", types.ChangeActionModify, types.ReplacementFalse, testResourceDetail("Vpc", "VpcId"), testParameterDetail("Az", "AvailabilityZone")),
+	)
+
+	return newTestModel(t, old), newTestModel(t, new)
+}
+
+func TestDiffChangeSetModels(t *testing.T) {
+	old, new := testDiffModels(t)
+	diff := DiffChangeSetModels(old, new)
+
+	resources := []string{}
+	for _, rd := range diff.Resources {
+		resources = append(resources, fmt.Sprintf("%s %s", rd.Status, rd.Path))
+	}
+	wantResources := []string{
+		"unchanged Database",
+		"removed Queue",
+		"unchanged Network",
+		"changed Network.Vpc",
+		"unchanged Network.Subnet",
+		"added Topic",
+	}
+	if strings.Join(resources, "\n") != strings.Join(wantResources, "\n") {
+		t.Errorf("resources = %q, want %q", resources, wantResources)
+	}
+
+	causes := []string{}
+	for _, cd := range diff.Causes {
+		causes = append(causes, fmt.Sprintf("%s %s", cd.Status, cd.Key))
+	}
+	wantCauses := []string{
+		"unchanged Network.Vpc:Properties.CidrBlock<-Network.Parameters.Cidr (Static)",
+		"unchanged Network.Subnet:Properties.VpcId<-Network.Vpc (Static)",
+		"added Network.Subnet:Properties.AvailabilityZone<-Network.Parameters.Az (Static)",
+	}
+	if strings.Join(causes, "\n") != strings.Join(wantCauses, "\n") {
+		t.Errorf("causes = %q, want %q", causes, wantCauses)
+	}
+}
+
+func TestDiffChangeSetModelsWithoutDifferences(t *testing.T) {
+	old, _ := testDiffModels(t)
+	_, new := testDiffModels(t)
+	if diff := DiffChangeSetModels(old, old); diff.HasDifferences() {
+		t.Errorf("HasDifferences() = true for the same changeset")
+	}
+	if diff := DiffChangeSetModels(old, new); !diff.HasDifferences() {
+		t.Errorf("HasDifferences() = false for different changesets")
+	}
+}
+
+func TestWriteDiffText(t *testing.T) {
+	old, new := testDiffModels(t)
+	var out bytes.Buffer
+	if err := WriteDiffText(&out, DiffChangeSetModels(old, new), "old", "new"); err != nil {
+		t.Fatalf("WriteDiffText() error = %v", err)
+	}
+	want := `Resources only changed in old:
+  - Queue (AWS::SQS::Queue): Remove
+
+Resources only changed in new:
+  + Topic (AWS::SNS::Topic): Add
+
+Resources with a different change:
+  ~ Network.Vpc (AWS::EC2::VPC): Modify (Replacement: False) -> Modify (Replacement: True)
+
+Causes only in new:
+  + Network.Subnet:Properties.AvailabilityZone<-Network.Parameters.Az (Static)
+
+`
+	if out.String() != want {
+		t.Errorf("WriteDiffText() = %q, want %q", out.String(), want)
+	}
+}
+
+func TestWriteDiffDOTGraph(t *testing.T) {
+	directModification := types.ResourceChangeDetail{
+		ChangeSource: types.ChangeSourceDirectModification,
+		Evaluation:   types.EvaluationTypeStatic,
+		Target:       &types.ResourceTargetDefinition{Attribute: types.ResourceAttributeTags},
+	}
+	tree := testTree("Root",
+		// Resources can be named like the nodes for parameters and direct modifications
+		testChange("DirectModification", "AWS::SNS::Topic", types.ChangeActionModify, types.ReplacementFalse, directModification),
+	)
+	network := testNestedTree(tree, "Network", "Root-Network-AAAA",
+		testChange("Vpc", "AWS::EC2::VPC", types.ChangeActionModify, types.ReplacementFalse, testParameterDetail("Cidr", "CidrBlock")),
+	)
+	testNestedTree(network, "Parameters", "Root-Network-Parameters-AAAA",
+		testChange("Cidr", "AWS::SSM::Parameter", types.ChangeActionModify, types.ReplacementFalse, directModification),
+	)
+	model := newTestModel(t, tree)
+
+	var out bytes.Buffer
+	if err := WriteDiffDOTGraph(&out, DiffChangeSetModels(model, model), "diff"); err != nil {
+		t.Fatalf("WriteDiffDOTGraph() error = %v", err)
+	}
+	graph := out.String()
+
+	for _, want := range []string{
+		"\n\t\"DirectModification\" [",
+		"\n\t\"_.DirectModification\" [",
+		"\n\tsubgraph \"cluster_Network\" {\n",
+		"\n\t\t\"Network.Vpc\" [",
+		"\n\t\t\"Network._.Parameters.Cidr\" [",
+		"\n\t\tsubgraph \"cluster_Network.Parameters\" {\n",
+		"\n\t\t\t\"Network.Parameters.Cidr\" [",
+		"\n\t\t\t\"Network.Parameters._.DirectModification\" [",
+		"\n\t\"_.DirectModification\" -> \"DirectModification\"",
+		"\n\t\"Network._.Parameters.Cidr\" -> \"Network.Vpc\"",
+	} {
+		if !strings.Contains(graph, want) {
+			t.Errorf("WriteDiffDOTGraph() does not contain %q:\n%s", want, graph)
+		}
+	}
+}
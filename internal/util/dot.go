@@ -0,0 +1,244 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// A writer for DOT source, which doesn't need the Graphviz library
+type dotWriter struct {
+	*bufio.Writer
+
+	opts *GraphOpts
+}
+
+type dotAttribute struct {
+	name  string
+	value string
+}
+
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return fmt.Sprintf(`"%s"`, s)
+}
+
+func dotClusterName(stackName string) string {
+	return fmt.Sprintf("cluster_%s", stackName)
+}
+
+func (w *dotWriter) writeAttributes(attributes []dotAttribute) {
+	if len(attributes) == 0 {
+		return
+	}
+	parts := []string{}
+	for _, a := range attributes {
+		if a.value == "" && a.name != "label" {
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s=%s", a.name, dotQuote(a.value)))
+	}
+	fmt.Fprintf(w, " [%s]", strings.Join(parts, ", "))
+}
+
+func (w *dotWriter) writeNode(indent string, nodeId string, attributes ...dotAttribute) {
+	fmt.Fprintf(w, "%s%s", indent, dotQuote(nodeId))
+	w.writeAttributes(append([]dotAttribute{{"id", nodeId}}, attributes...))
+	fmt.Fprintf(w, ";\n")
+}
+
+// Write a record node with one field per port
+func (w *dotWriter) writeRecordNode(indent string, nodeId string, fields []recordField, color color) {
+	// The label is escaped for the record already, and only needs the quotes escaped. A trailing backslash
+	// would escape the closing quote.
+	label := strings.ReplaceAll(recordLabel(fields), `"`, `\"`)
+	if strings.HasSuffix(label, `\`) {
+		label += " "
+	}
+	fmt.Fprintf(w, "%s%s", indent, dotQuote(nodeId))
+	w.writeAttributes([]dotAttribute{{"id", nodeId}, {"shape", "record"}, {"color", color}})
+	fmt.Fprintf(w, " [label=\"%s\"];\n", label)
+}
+
+func resourceChangeNodeAttributes(r *Resource) []dotAttribute {
+	attributes := []dotAttribute{
+		{"label", strings.Join(resourceLabel(r), "\n")},
+		{"tooltip", resourceTooltip(r)},
+	}
+	if r.DriftedChange() {
+		attributes = append(attributes, dotAttribute{"penwidth", fmt.Sprint(driftedPenWidth)})
+	}
+	rc := r.ResourceChange()
+	if rc == nil || r.Change.Type != types.ChangeTypeResource {
+		return attributes
+	}
+	border, fill := resourceChangeColors(*rc)
+	attributes = append(attributes, dotAttribute{"color", border}, dotAttribute{"fillcolor", fill})
+	if fill != "" {
+		attributes = append(attributes, dotAttribute{"style", "filled"})
+	}
+	return attributes
+}
+
+func (w *dotWriter) writeStack(stack *Stack, indent string) {
+	for _, r := range stack.Resources {
+		if r.NestedStack != nil {
+			nested := r.NestedStack
+			fmt.Fprintf(w, "%ssubgraph %s {\n", indent, dotQuote(dotClusterName(nested.Name)))
+			nestedIndent := indent + "\t"
+			// Use the id of the stack resource, so that the cluster can be found in SVG output
+			fmt.Fprintf(w, "%sid=%s;\n", nestedIndent, dotQuote(r.Id()))
+			fmt.Fprintf(w, "%slabel=%s;\n", nestedIndent, dotQuote(strings.Join(resourceLabel(r), "\n")))
+			if tooltip := resourceTooltip(r); tooltip != "" {
+				fmt.Fprintf(w, "%stooltip=%s;\n", nestedIndent, dotQuote(tooltip))
+			}
+			var border, fill color
+			if nested.Err != nil {
+				border, fill = failedStackColor, failedStackFillColor
+			} else if rc := r.ResourceChange(); rc != nil && r.Change.Type == types.ChangeTypeResource {
+				border, fill = resourceChangeColors(*rc)
+			}
+			for _, a := range []dotAttribute{{"color", border}, {"fillcolor", fill}} {
+				if a.value != "" {
+					fmt.Fprintf(w, "%s%s=%s;\n", nestedIndent, a.name, dotQuote(a.value))
+				}
+			}
+			if fill != "" {
+				fmt.Fprintf(w, "%sstyle=filled;\n", nestedIndent)
+			}
+			if r.DriftedChange() {
+				fmt.Fprintf(w, "%spenwidth=%d;\n", nestedIndent, driftedPenWidth)
+			}
+			w.writeStack(nested, nestedIndent)
+
+			// Edges point to this hidden node, and get adjusted to point to the cluster instead
+			w.writeNode(nestedIndent, makeNodeId(nested.Name, stackNodeName),
+				dotAttribute{"shape", "none"},
+				dotAttribute{"label", ""},
+				dotAttribute{"style", "invis"},
+				dotAttribute{"comment", dotClusterName(nested.Name)},
+			)
+			fmt.Fprintf(w, "%s}\n", indent)
+			continue
+		}
+
+		shape := "box"
+		if verdict, _ := r.StackPolicyVerdict(); verdict != "" {
+			shape = blockedResourceShape
+		}
+		attributes := []dotAttribute{{"shape", shape}}
+		if r.Change != nil {
+			attributes = append(attributes, resourceChangeNodeAttributes(r)...)
+		}
+		if len(r.Elided) > 0 {
+			attributes = append(attributes,
+				dotAttribute{"label", strings.Join(resourceLabel(r), "\n")},
+				dotAttribute{"tooltip", resourceTooltip(r)},
+				dotAttribute{"style", elidedResourceStyle},
+			)
+		}
+		w.writeNode(indent, r.Id(), attributes...)
+	}
+
+	used, unused := w.opts.splitParameters(stack)
+	if len(used) > 0 {
+		w.writeRecordNode(indent, makeNodeId(stack.Name, parametersNodeName), parameterRecordFields(used), usedParameterColor)
+	}
+	if len(unused) > 0 {
+		w.writeRecordNode(indent, makeNodeId(stack.Name, unusedParametersNodeName), unusedParameterRecordFields(unused), unusedParameterColor)
+	}
+	if fields := outputRecordFields(stack); len(fields) > 0 {
+		w.writeRecordNode(indent, makeNodeId(stack.Name, outputsNodeName), fields, outputColor)
+	}
+
+	for _, cause := range stack.Causes {
+		if cause.Source == types.ChangeSourceDirectModification {
+			w.writeNode(indent, makeNodeId(stack.Name, "Direct modification"),
+				dotAttribute{"shape", "none"},
+				dotAttribute{"label", "Direct modification"},
+			)
+			break
+		}
+	}
+
+	for _, cause := range stack.Causes {
+		w.writeEdge(indent, stack, cause)
+	}
+}
+
+// The node for the resource, or the hidden node inside the cluster for nested stacks
+func dotResourceNode(r *Resource) (nodeId string, clusterName string) {
+	if r.NestedStack != nil {
+		return makeNodeId(r.NestedStack.Name, stackNodeName), dotClusterName(r.NestedStack.Name)
+	}
+	return r.Id(), ""
+}
+
+func (w *dotWriter) writeEdge(indent string, stack *Stack, cause *Cause) {
+	var tail, tailPort, tailCluster string
+	switch {
+	case cause.Parameter != nil:
+		tail = makeNodeId(stack.Name, parametersNodeName)
+		tailPort = cause.Parameter.Name
+	case cause.SourceOutput() != "":
+		tail = makeNodeId(cause.Resource.NestedStack.Name, outputsNodeName)
+		tailPort = cause.SourceOutput()
+	case cause.Resource != nil:
+		tail, tailCluster = dotResourceNode(cause.Resource)
+	default:
+		tail = makeNodeId(stack.Name, "Direct modification")
+	}
+	head, headCluster := dotResourceNode(cause.Changed)
+
+	tailSpec := dotQuote(tail)
+	if tailPort != "" {
+		tailSpec = fmt.Sprintf("%s:%s", tailSpec, dotQuote(tailPort))
+	}
+	fmt.Fprintf(w, "%s%s -> %s", indent, tailSpec, dotQuote(head))
+
+	attributes := []dotAttribute{}
+	switch cause.Evaluation {
+	case types.EvaluationTypeStatic:
+		attributes = append(attributes, dotAttribute{"style", "solid"})
+	case types.EvaluationTypeDynamic:
+		attributes = append(attributes, dotAttribute{"style", "dashed"})
+	}
+	attributes = append(attributes, dotAttribute{"tooltip", causeEdgeTooltip(cause)})
+	// Point out the changes that force a replacement
+	if color, arrowHead := recreationEdgeStyle(cause); color != "" {
+		attributes = append(attributes,
+			dotAttribute{"color", color},
+			dotAttribute{"fontcolor", color},
+			dotAttribute{"arrowhead", arrowHead},
+			dotAttribute{"penwidth", "2"},
+		)
+	}
+	attributes = append(attributes,
+		dotAttribute{"headlabel", causeTargetLabel(cause)},
+		dotAttribute{"ltail", tailCluster},
+		dotAttribute{"lhead", headCluster},
+	)
+	w.writeAttributes(attributes)
+	fmt.Fprintf(w, ";\n")
+}
+
+// Write the changeset model as DOT source
+//
+// This produces the same structure as the Graphviz renderer (clusters for nested stacks, a record node for
+// the parameters, logical heads and tails for edges to nested stacks), but without needing the Graphviz library.
+func WriteDOTGraph(out io.Writer, model *ChangeSetModel, name string, opts *GraphOpts) error {
+	w := &dotWriter{bufio.NewWriter(out), opts}
+
+	fmt.Fprintf(w, "digraph %s {\n", dotQuote(name))
+	fmt.Fprintf(w, "\tcompound=true;\n")
+	fmt.Fprintf(w, "\trankdir=LR;\n")
+	w.writeStack(model.Root, "\t")
+	fmt.Fprintf(w, "}\n")
+
+	return w.Flush()
+}
@@ -0,0 +1,241 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// Criteria for the changes to show
+//
+// All given criteria must match for a change to be shown, and within each criterion one of the values must match.
+// Stack names and resource types are glob patterns, like in policy rules.
+type ChangeFilter struct {
+	// CloudFormation change actions
+	Actions     []string
+	Replacement []string
+
+	ResourceTypes []string
+	Stacks        []string
+	// Changes that only touch these scopes are left out
+	ExcludeScopes []string
+}
+
+// Check the values and patterns of the filter
+func (f *ChangeFilter) Validate() error {
+	if err := validateValues("action", f.Actions, types.ChangeAction("").Values()); err != nil {
+		return err
+	}
+	if err := validateValues("replacement", f.Replacement, types.Replacement("").Values()); err != nil {
+		return err
+	}
+	if err := validateValues("scope", f.ExcludeScopes, types.ResourceAttribute("").Values()); err != nil {
+		return err
+	}
+	if err := validatePatterns(f.Stacks); err != nil {
+		return err
+	}
+	return validatePatterns(f.ResourceTypes)
+}
+
+// Whether the filter has no criteria, and so keeps all changes
+func (f *ChangeFilter) IsEmpty() bool {
+	return len(f.Actions) == 0 && len(f.Replacement) == 0 && len(f.ResourceTypes) == 0 && len(f.Stacks) == 0 && len(f.ExcludeScopes) == 0
+}
+
+func (f *ChangeFilter) excludesScope(change *types.ResourceChange) bool {
+	if len(change.Scope) == 0 {
+		return false
+	}
+	for _, scope := range change.Scope {
+		if !matchesAnyValue(f.ExcludeScopes, string(scope)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Whether the change of the resource passes the filter
+func (f *ChangeFilter) Matches(r *Resource) bool {
+	change := r.ResourceChange()
+	if change == nil {
+		return false
+	}
+	if len(f.Stacks) > 0 && !matchesAnyPattern(f.Stacks, r.Stack.Name) {
+		return false
+	}
+	if len(f.ResourceTypes) > 0 && !matchesAnyPattern(f.ResourceTypes, aws.ToString(change.ResourceType)) {
+		return false
+	}
+	if len(f.Actions) > 0 && !matchesAnyValue(f.Actions, string(change.Action)) {
+		return false
+	}
+	if len(f.Replacement) > 0 && !matchesAnyValue(f.Replacement, string(change.Replacement)) {
+		return false
+	}
+	return !f.excludesScope(change)
+}
+
+// Changes left out by a filter that connect kept nodes, shown as a single "…" node
+type elidedChanges struct {
+	logicalResourceId string
+	// Logical ids of the left out resources
+	resources []string
+
+	// Cause edges from kept nodes into the left out changes, and from the left out changes into kept changes
+	entries []*Cause
+	exits   []*Cause
+}
+
+// Whether a change is left out
+func (subset *modelSubset) hidden(r *Resource) bool {
+	return r.Change != nil && !subset.keepResource[r]
+}
+
+// Whether following the kept cause edges from the effects leads to the target
+func (subset *modelSubset) reaches(effects []*Cause, target *Resource) bool {
+	seen := map[*Resource]bool{}
+	queue := append([]*Cause{}, effects...)
+	for len(queue) > 0 {
+		cause := queue[0]
+		queue = queue[1:]
+		if !subset.keepCause[cause] || seen[cause.Changed] {
+			continue
+		}
+		if cause.Changed == target {
+			return true
+		}
+		seen[cause.Changed] = true
+		queue = append(queue, cause.Changed.Effects...)
+	}
+	return false
+}
+
+// Find the left out changes through which kept nodes cause the change of the target, nil if there are none
+//
+// Kept nodes that also reach the target through kept changes don't need the left out ones.
+func (subset *modelSubset) elide(target *Resource) *elidedChanges {
+	result := &elidedChanges{}
+	seen := map[*Resource]bool{}
+	var visit func(r *Resource)
+	visit = func(r *Resource) {
+		if seen[r] {
+			return
+		}
+		seen[r] = true
+		result.resources = append(result.resources, r.LogicalResourceId)
+		for _, cause := range r.Causes {
+			switch {
+			case cause.Resource != nil && subset.hidden(cause.Resource):
+				visit(cause.Resource)
+			case cause.Parameter != nil:
+				if !subset.reaches(cause.Parameter.Effects, target) {
+					result.entries = append(result.entries, cause)
+				}
+			case cause.Resource != nil:
+				if !subset.reaches(cause.Resource.Effects, target) {
+					result.entries = append(result.entries, cause)
+				}
+			}
+		}
+	}
+	for _, cause := range target.Causes {
+		if cause.Resource != nil && subset.hidden(cause.Resource) {
+			result.exits = append(result.exits, cause)
+			visit(cause.Resource)
+		}
+	}
+	if len(result.entries) == 0 {
+		return nil
+	}
+	sort.Strings(result.resources)
+	result.logicalResourceId = fmt.Sprintf("…(%s)", strings.Join(result.resources, ","))
+	return result
+}
+
+// Decide which causes and parameters of the stack to keep, and which left out changes to elide
+func (subset *modelSubset) filterStack(stack *Stack) {
+	usedParameters := map[*Parameter]bool{}
+	for _, cause := range stack.Causes {
+		if !subset.keepResource[cause.Changed] || (cause.Resource != nil && subset.hidden(cause.Resource)) {
+			continue
+		}
+		subset.keepCause[cause] = true
+		if cause.Parameter != nil {
+			usedParameters[cause.Parameter] = true
+		}
+		if cause.Resource != nil {
+			subset.keep(cause.Resource)
+		}
+	}
+
+	// Changes with the same left out changes share the node standing in for them
+	elidedById := map[string]*elidedChanges{}
+	for _, r := range stack.Changes() {
+		if !subset.keepResource[r] {
+			continue
+		}
+		elided := subset.elide(r)
+		if elided == nil {
+			continue
+		}
+		if existing, present := elidedById[elided.logicalResourceId]; present {
+			for _, cause := range elided.entries {
+				if !contains(existing.entries, cause) {
+					existing.entries = append(existing.entries, cause)
+				}
+			}
+			existing.exits = append(existing.exits, elided.exits...)
+			continue
+		}
+		elidedById[elided.logicalResourceId] = elided
+		subset.elided[stack] = append(subset.elided[stack], elided)
+	}
+	for _, elided := range subset.elided[stack] {
+		for _, cause := range elided.entries {
+			if cause.Parameter != nil {
+				usedParameters[cause.Parameter] = true
+			}
+			if cause.Resource != nil {
+				subset.keep(cause.Resource)
+			}
+		}
+	}
+
+	// Parameters whose changes are all left out would otherwise look like they cause no changes
+	for _, p := range stack.Parameters {
+		if len(p.Effects) > 0 && !usedParameters[p] {
+			subset.dropParameter[p] = true
+		}
+	}
+}
+
+// A copy of the model with only the changes that pass the filter
+//
+// The stack resources of nested stacks containing kept changes are kept as well, and so are nested stacks that
+// could not be processed. Cause edges between kept nodes are kept, and when kept nodes cause a kept change only
+// through changes that are left out, a "…" node stands in for these changes.
+func (m *ChangeSetModel) Filter(filter *ChangeFilter) *ChangeSetModel {
+	subset := &modelSubset{
+		keepResource:  map[*Resource]bool{},
+		keepCause:     map[*Cause]bool{},
+		dropParameter: map[*Parameter]bool{},
+		elided:        map[*Stack][]*elidedChanges{},
+	}
+	m.Root.Walk(func(stack *Stack) error {
+		for _, r := range stack.Changes() {
+			if filter.Matches(r) || (r.NestedStack != nil && r.NestedStack.Err != nil) {
+				subset.keep(r)
+			}
+		}
+		return nil
+	})
+	m.Root.Walk(func(stack *Stack) error {
+		subset.filterStack(stack)
+		return nil
+	})
+	return subset.apply(m.Root)
+}
@@ -0,0 +1,190 @@
+package util
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func TestChangeFilterMatches(t *testing.T) {
+	tagsOnly := testChange("Queue", "AWS::SQS::Queue", types.ChangeActionModify, types.ReplacementFalse)
+	tagsOnly.ResourceChange.Scope = []types.ResourceAttribute{types.ResourceAttributeTags}
+	model := newTestModel(t, testTree("prod-app",
+		testChange("Database", "AWS::RDS::DBInstance", types.ChangeActionModify, types.ReplacementConditional),
+		tagsOnly,
+		testChange("Topic", "AWS::SNS::Topic", types.ChangeActionAdd, ""),
+	))
+	database, queue, topic := model.Root.FindResource("Database"), model.Root.FindResource("Queue"), model.Root.FindResource("Topic")
+
+	tests := []struct {
+		name   string
+		filter ChangeFilter
+		want   []*Resource
+	}{
+		{"empty", ChangeFilter{}, []*Resource{database, queue, topic}},
+		{"actions", ChangeFilter{Actions: []string{"add", "Remove"}}, []*Resource{topic}},
+		{"replacement", ChangeFilter{Replacement: []string{"True", "Conditional"}}, []*Resource{database}},
+		{"resource types", ChangeFilter{ResourceTypes: []string{"AWS::S?S::*"}}, []*Resource{queue, topic}},
+		{"stacks", ChangeFilter{Stacks: []string{"prod-*"}}, []*Resource{database, queue, topic}},
+		{"other stacks", ChangeFilter{Stacks: []string{"test-*"}}, []*Resource{}},
+		{"excluded scopes", ChangeFilter{ExcludeScopes: []string{"Tags"}}, []*Resource{database, topic}},
+		{"all criteria", ChangeFilter{Actions: []string{"Modify"}, ResourceTypes: []string{"AWS::SQS::*"}}, []*Resource{queue}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []*Resource{}
+			for _, r := range model.Root.Changes() {
+				if tt.filter.Matches(r) {
+					got = append(got, r)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Matches() kept %d changes, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("Matches() kept %s, want %s", got[i].Id(), tt.want[i].Id())
+				}
+			}
+		})
+	}
+}
+
+func TestChangeFilterValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter ChangeFilter
+		valid  bool
+	}{
+		{"empty", ChangeFilter{}, true},
+		{"known values", ChangeFilter{Actions: []string{"modify"}, Replacement: []string{"conditional"}, ExcludeScopes: []string{"tags"}}, true},
+		{"unknown action", ChangeFilter{Actions: []string{"Replace"}}, false},
+		{"unknown replacement", ChangeFilter{Replacement: []string{"Maybe"}}, false},
+		{"unknown scope", ChangeFilter{ExcludeScopes: []string{"Tag"}}, false},
+		{"invalid stack pattern", ChangeFilter{Stacks: []string{"prod-["}}, false},
+		{"invalid resource type pattern", ChangeFilter{ResourceTypes: []string{"AWS::[::*"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.filter.Validate(); (err == nil) != tt.valid {
+				t.Errorf("Validate() error = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
+
+// The parameters, resources and cause edges of a stack, sorted
+func describeFilteredStack(stack *Stack) (parameters []string, resources []string, causes []string) {
+	parameters, resources, causes = []string{}, []string{}, []string{}
+	for _, p := range stack.Parameters {
+		parameters = append(parameters, p.Name)
+	}
+	for _, r := range stack.Resources {
+		description := r.LogicalResourceId
+		if len(r.Elided) > 0 {
+			description += "[" + strings.Join(r.Elided, ",") + "]"
+		}
+		resources = append(resources, description)
+	}
+	for _, cause := range stack.Causes {
+		causes = append(causes, cause.Id())
+	}
+	sort.Strings(parameters)
+	sort.Strings(resources)
+	sort.Strings(causes)
+	return parameters, resources, causes
+}
+
+func TestFilter(t *testing.T) {
+	// Cidr -> Vpc -> Subnet -> SubnetGroup -> Database, and Environment -> Queue
+	tree := testTree("Stack",
+		testChange("Vpc", "AWS::EC2::VPC", types.ChangeActionModify, types.ReplacementTrue, testParameterDetail("Cidr", "CidrBlock")),
+		testChange("Subnet", "AWS::EC2::Subnet", types.ChangeActionModify, types.ReplacementTrue, testResourceDetail("Vpc", "VpcId")),
+		testChange("SubnetGroup", "AWS::RDS::DBSubnetGroup", types.ChangeActionModify, types.ReplacementTrue, testResourceDetail("Subnet", "SubnetIds")),
+		testChange("Database", "AWS::RDS::DBInstance", types.ChangeActionModify, types.ReplacementConditional, testResourceDetail("SubnetGroup", "DBSubnetGroupName")),
+		testChange("Queue", "AWS::SQS::Queue", types.ChangeActionModify, types.ReplacementFalse, testParameterDetail("Environment", "QueueName")),
+	)
+	model := newTestModel(t, tree)
+
+	tests := []struct {
+		name           string
+		filter         ChangeFilter
+		wantParameters []string
+		wantResources  []string
+		wantCauses     []string
+	}{
+		{
+			name:           "no filter",
+			filter:         ChangeFilter{},
+			wantParameters: []string{"Cidr", "Environment"},
+			wantResources:  []string{"Database", "Queue", "Subnet", "SubnetGroup", "Vpc"},
+			wantCauses: []string{
+				"Stack.Database:Properties.DBSubnetGroupName<-Stack.SubnetGroup",
+				"Stack.Queue:Properties.QueueName<-Stack.Parameters.Environment",
+				"Stack.Subnet:Properties.VpcId<-Stack.Vpc",
+				"Stack.SubnetGroup:Properties.SubnetIds<-Stack.Subnet",
+				"Stack.Vpc:Properties.CidrBlock<-Stack.Parameters.Cidr",
+			},
+		},
+		{
+			name:           "elided chain from a parameter",
+			filter:         ChangeFilter{ResourceTypes: []string{"AWS::RDS::*"}},
+			wantParameters: []string{"Cidr"},
+			wantResources:  []string{"Database", "SubnetGroup", "…(Subnet,Vpc)[Subnet,Vpc]"},
+			wantCauses: []string{
+				"Stack.Database:Properties.DBSubnetGroupName<-Stack.SubnetGroup",
+				"Stack.SubnetGroup:Properties.SubnetIds<-Stack.…(Subnet,Vpc)",
+				"Stack.…(Subnet,Vpc):Properties.CidrBlock<-Stack.Parameters.Cidr",
+			},
+		},
+		{
+			name:           "elided change between kept changes",
+			filter:         ChangeFilter{ResourceTypes: []string{"AWS::RDS::DBSubnetGroup", "AWS::EC2::VPC"}},
+			wantParameters: []string{"Cidr"},
+			wantResources:  []string{"SubnetGroup", "Vpc", "…(Subnet)[Subnet]"},
+			wantCauses: []string{
+				"Stack.SubnetGroup:Properties.SubnetIds<-Stack.…(Subnet)",
+				"Stack.Vpc:Properties.CidrBlock<-Stack.Parameters.Cidr",
+				"Stack.…(Subnet):Properties.VpcId<-Stack.Vpc",
+			},
+		},
+		{
+			name:           "kept chain",
+			filter:         ChangeFilter{ResourceTypes: []string{"AWS::EC2::*"}},
+			wantParameters: []string{"Cidr"},
+			wantResources:  []string{"Subnet", "Vpc"},
+			wantCauses: []string{
+				"Stack.Subnet:Properties.VpcId<-Stack.Vpc",
+				"Stack.Vpc:Properties.CidrBlock<-Stack.Parameters.Cidr",
+			},
+		},
+		{
+			name:           "nothing kept",
+			filter:         ChangeFilter{Actions: []string{"Remove"}},
+			wantParameters: []string{},
+			wantResources:  []string{},
+			wantCauses:     []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parameters, resources, causes := describeFilteredStack(model.Filter(&tt.filter).Root)
+			if strings.Join(parameters, "\n") != strings.Join(tt.wantParameters, "\n") {
+				t.Errorf("parameters = %q, want %q", parameters, tt.wantParameters)
+			}
+			if strings.Join(resources, "\n") != strings.Join(tt.wantResources, "\n") {
+				t.Errorf("resources = %q, want %q", resources, tt.wantResources)
+			}
+			if strings.Join(causes, "\n") != strings.Join(tt.wantCauses, "\n") {
+				t.Errorf("causes = %q, want %q", causes, tt.wantCauses)
+			}
+		})
+	}
+
+	// Filtering copies the model
+	if parameters, _, _ := describeFilteredStack(model.Root); len(parameters) != 2 {
+		t.Errorf("Filter() changed the parameters of the model to %q", parameters)
+	}
+}
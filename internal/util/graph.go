@@ -1,13 +1,11 @@
+//go:build !nocgo
+
 package util
 
 import (
-	"context"
 	"fmt"
 	"strings"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/aws/arn"
-	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
 	"github.com/goccy/go-graphviz/cgraph"
 	log "github.com/sirupsen/logrus"
@@ -20,46 +18,11 @@ type changeSetGraph struct {
 
 	// Nodes, in a "flat" map indexed by StackName.LogicalResourceId
 	nodes map[string]*cgraph.Node
-}
 
-type cloudformationClient interface {
-	cloudformation.DescribeChangeSetAPIClient
+	opts *GraphOpts
 }
 
-type color = string
-
-const (
-	modifiedResourceColor color = "/paired10/2"
-	addedResourceColor    color = "/paired10/4"
-	removedResourceColor  color = "/paired10/6"
-	importedResourceColor color = "/paired10/8"
-	dynamicResourceColor  color = "/paired10/12"
-
-	unusedParameterColor color = "/paired10/9"
-	usedParameterColor   color = "/paired10/10"
-
-	maybeReplacedResourceFillColor color = "/paired10/1"
-	replacedResourceFillColor      color = "/paired10/2"
-	removedResourceFillColor       color = "/paired10/5"
-
-	parametersNodeName = "Parameters"
-	stackNodeName      = "_"
-)
-
-func contains[E comparable](s []E, e E) bool {
-	for _, a := range s {
-		if a == e {
-			return true
-		}
-	}
-	return false
-}
-
-// TODO: Redesign the API, parameter nodes are very different than resource (change) nodes
-type configureNodeFunc func(*cgraph.Node) error
-
-func configureParameterNode(node *cgraph.Node) error {
-	// Label will be build incrementally when changes are caused by parameters
+func configureRecordNode(node *cgraph.Node) error {
 	node.SetLabel("")
 	node.SetShape("record")
 	return nil
@@ -76,6 +39,20 @@ func configureResourceChangeNode(clusterName *string) configureNodeFunc {
 	}
 }
 
+func configureElidedNode(node *cgraph.Node) error {
+	node.SetShape(cgraph.BoxShape)
+	node.SetStyle(elidedResourceStyle)
+	return nil
+}
+
+func configureDirectModificationNode(node *cgraph.Node) error {
+	node.SetLabel("Direct modification")
+	node.SetShape(cgraph.NoneShape)
+	return nil
+}
+
+type configureNodeFunc func(*cgraph.Node) error
+
 func (csg *changeSetGraph) makeStack(parentStackName string, stackName string, name string) (*cgraph.Graph, error) {
 	_, present := csg.graphs[stackName]
 	if present {
@@ -91,16 +68,14 @@ func (csg *changeSetGraph) makeStack(parentStackName string, stackName string, n
 	// "cluster_" prefix is needed to draw the box around the subgraph
 	graph := parentGraph.SubGraph(fmt.Sprintf("cluster_%s", stackName), 1)
 	graph.SetLabel(fmt.Sprintf("%s\n%s", name, stackName))
+	// Use the id of the stack resource, so that the cluster can be found in SVG output
+	graph.SafeSet("id", makeNodeId(parentStackName, name), "")
 	csg.graphs[stackName] = graph
 	return graph, nil
 }
 
-func (*changeSetGraph) makeNodeId(stackName string, name string) string {
-	return fmt.Sprintf("%s.%s", stackName, name)
-}
-
 func (csg *changeSetGraph) findNode(stackName string, name string) (*cgraph.Node, error) {
-	nodeId := csg.makeNodeId(stackName, name)
+	nodeId := makeNodeId(stackName, name)
 	node, present := csg.nodes[nodeId]
 	if !present {
 		return nil, fmt.Errorf("cannot find node %v", nodeId)
@@ -109,7 +84,7 @@ func (csg *changeSetGraph) findNode(stackName string, name string) (*cgraph.Node
 }
 
 func (csg *changeSetGraph) makeOrFindNode(stackName, name string, configureNode configureNodeFunc) (*cgraph.Node, error) {
-	nodeId := csg.makeNodeId(stackName, name)
+	nodeId := makeNodeId(stackName, name)
 
 	var node *cgraph.Node
 	node, present := csg.nodes[nodeId]
@@ -136,123 +111,84 @@ func (csg *changeSetGraph) makeOrFindNode(stackName, name string, configureNode
 	return node, nil
 }
 
+// Build a record node with one port per name
+func (csg *changeSetGraph) makeRecordNode(stackName string, name string, fields []recordField, color color) (*cgraph.Node, error) {
+	node, err := csg.makeOrFindNode(stackName, name, configureRecordNode)
+	if err != nil {
+		return nil, err
+	}
+
+	// We want the properties record to be always TB ranking, so flip the direction if needed
+	// XXX: Ugly, do this with a property?
+	label := recordLabel(fields)
+	if csg.rootGraph.Get("rankdir") == "LR" || csg.rootGraph.Get("rankdir") == "RL" {
+		label = fmt.Sprintf("{%s}", label)
+	}
+	node.SetLabel(label)
+	node.SetColor(color)
+	return node, nil
+}
+
+// Build the record node listing the parameters of the stack that cause changes
+func (csg *changeSetGraph) makeParametersNode(stack *Stack, parameters []*Parameter) (*cgraph.Node, error) {
+	return csg.makeRecordNode(stack.Name, parametersNodeName, parameterRecordFields(parameters), usedParameterColor)
+}
+
+// Build the record node listing the shown parameters of the stack that cause no changes
+func (csg *changeSetGraph) makeUnusedParametersNode(stack *Stack, parameters []*Parameter) (*cgraph.Node, error) {
+	return csg.makeRecordNode(stack.Name, unusedParametersNodeName, unusedParameterRecordFields(parameters), unusedParameterColor)
+}
+
+// Build the record node listing the outputs of the nested stack that cause changes in the parent stack
+func (csg *changeSetGraph) makeOutputsNode(stack *Stack) (*cgraph.Node, error) {
+	return csg.makeRecordNode(stack.Name, outputsNodeName, outputRecordFields(stack), outputColor)
+}
+
 type changeCause struct {
 	node *cgraph.Node
 	// If set: a port on this node to connect
 	port *string
 
-	// The detail information about this cause
-	detail types.ResourceChangeDetail
-}
-
-func makeTargetHash(target *types.ResourceTargetDefinition) string {
-	return fmt.Sprintf("%s.%s", target.Attribute, aws.ToString(target.Name))
+	cause *Cause
 }
 
-func (csg *changeSetGraph) findChangeCauses(stackName string, change *types.ResourceChange) ([]changeCause, error) {
-	result := []changeCause{}
-
-	// Walk through all targets, and try to figure out what they refer to.
-	// See https://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/using-cfn-updating-stacks-changesets-samples.html for
-	// some good examples and hints on how to read the changes
-	for _, detail := range change.Details {
-		causingEntity := aws.ToString(detail.CausingEntity)
-
-		if causingEntity == "" && detail.Evaluation == types.EvaluationTypeDynamic {
-			// Changes to parameters produce both a "dynamic" and a "static" change for the same target. The "static"
-			// change has better information, and we want to skip the dynamic one in that case.
-			targetHash := makeTargetHash(detail.Target)
-			foundMatchingStaticDetail := false
-			for _, innerDetail := range change.Details {
-				if innerDetail.Evaluation == types.EvaluationTypeStatic {
-					innerTargetHash := makeTargetHash(innerDetail.Target)
-					if innerTargetHash == targetHash {
-						foundMatchingStaticDetail = true
-						break
-					}
-				}
-			}
-			if foundMatchingStaticDetail {
-				continue
-			}
+func (csg *changeSetGraph) findChangeCause(stack *Stack, cause *Cause) (*changeCause, error) {
+	switch cause.Source {
+	case types.ChangeSourceDirectModification:
+		node, err := csg.makeOrFindNode(stack.Name, "Direct modification", configureDirectModificationNode)
+		if err != nil {
+			return nil, err
 		}
-
-		switch detail.ChangeSource {
-		case types.ChangeSourceDirectModification:
-			helperNode, err := csg.makeOrFindNode(stackName, "Direct modification", func(node *cgraph.Node) error {
-				node.SetLabel("Direct modification")
-				node.SetShape(cgraph.NoneShape)
-				return nil
-			})
-			if err == nil {
-				result = append(result, changeCause{helperNode, nil, detail})
-			}
-		case types.ChangeSourceParameterReference:
-			node, err := csg.findNode(stackName, parametersNodeName)
+		return &changeCause{node, nil, cause}, nil
+	case types.ChangeSourceParameterReference:
+		node, err := csg.findNode(stack.Name, parametersNodeName)
+		if err != nil {
+			return nil, err
+		}
+		return &changeCause{node, &cause.Parameter.Name, cause}, nil
+	case types.ChangeSourceResourceReference, types.ChangeSourceResourceAttribute:
+		if output := cause.SourceOutput(); output != "" {
+			node, err := csg.findNode(cause.Resource.NestedStack.Name, outputsNodeName)
 			if err != nil {
-				// Not yet there, just make it
-				node, err = csg.makeOrFindNode(stackName, parametersNodeName, configureParameterNode)
-			}
-			if err == nil {
-				// Check the label, we may need to add the parameter
-				// We want the properties record to be always TB ranking, so flip the direction if needed
-				// XXX: Ugly, do this with a property?
-				var parseLabel func(string) []string
-				var format string
-				if csg.rootGraph.Get("rankdir") == "LR" || csg.rootGraph.Get("rankdir") == "RL" {
-					format = "{%s}"
-					parseLabel = func(s string) []string {
-						return strings.Split(s[1:len(s)-1], "|")
-					}
-				} else {
-					format = "%s"
-					parseLabel = func(s string) []string {
-						return strings.Split(s, "|")
-					}
-				}
-
-				parameterSpec := fmt.Sprintf("<%s>%s", causingEntity, causingEntity)
-				label := node.Get("label")
-				if label == "" {
-					node.SetLabel(fmt.Sprintf(format, parameterSpec))
-				} else {
-					knownParameterSpecs := parseLabel(label)
-					if !contains(knownParameterSpecs, parameterSpec) {
-						newLabel := fmt.Sprintf(format, strings.Join(append(knownParameterSpecs, parameterSpec), "|"))
-						node.SetLabel(newLabel)
-					}
-				}
-
-				node.SetColor(usedParameterColor)
-				result = append(result, changeCause{node, &causingEntity, detail})
-			}
-		case types.ChangeSourceResourceReference:
-			// XXX: We could "record" this, too?
-			node, err := csg.makeOrFindNode(stackName, causingEntity, configureResourceChangeNode(nil))
-			if err == nil {
-				result = append(result, changeCause{node, nil, detail})
-			}
-		case types.ChangeSourceResourceAttribute:
-			// CausingEntity is "LogicalResourceId.Attribute", for a nested stack "Attribute" could also be "Outputs.NameOfOutput"
-			// We only care about the first part here
-			// XXX: We could "record" this, too?
-			logicalResourceId := causingEntity[:strings.IndexByte(causingEntity, '.')]
-			node, err := csg.makeOrFindNode(stackName, logicalResourceId, configureResourceChangeNode(nil))
-			if err == nil {
-				result = append(result, changeCause{node, nil, detail})
+				return nil, err
 			}
+			return &changeCause{node, &output, cause}, nil
 		}
+		node, err := csg.makeOrFindNode(stack.Name, cause.Resource.LogicalResourceId, configureResourceChangeNode(nil))
+		if err != nil {
+			return nil, err
+		}
+		return &changeCause{node, nil, cause}, nil
 	}
-
-	if len(result) == 0 {
-		log.Debugf("cannot find any understood change cause, %v", change)
-	}
-	return result, nil
+	return nil, fmt.Errorf("unsupported change source %q", cause.Source)
 }
 
 type resourceNode interface {
 	SetColors(border string, fill string)
 	SetLabel(string)
+	SetTooltip(string)
+	SetPenWidth(float64)
+	SetShape(string)
 }
 
 type graphResourceNode struct {
@@ -269,6 +205,15 @@ func (g *graphResourceNode) SetColors(border string, fill string) {
 func (g *graphResourceNode) SetLabel(s string) {
 	g.Graph.SetLabel(s)
 }
+func (g *graphResourceNode) SetTooltip(s string) {
+	g.Graph.SafeSet("tooltip", s, "")
+}
+func (g *graphResourceNode) SetPenWidth(w float64) {
+	g.Graph.SafeSet("penwidth", fmt.Sprint(w), "1")
+}
+func (g *graphResourceNode) SetShape(s string) {
+	// Clusters are always boxes
+}
 
 type nodeResourceNode struct {
 	*cgraph.Node
@@ -284,6 +229,15 @@ func (n *nodeResourceNode) SetColors(border string, fill string) {
 func (n *nodeResourceNode) SetLabel(s string) {
 	n.Node.SetLabel(s)
 }
+func (n *nodeResourceNode) SetTooltip(s string) {
+	n.Node.SetTooltip(s)
+}
+func (n *nodeResourceNode) SetPenWidth(w float64) {
+	n.Node.SetPenWidth(w)
+}
+func (n *nodeResourceNode) SetShape(s string) {
+	n.Node.SetShape(cgraph.Shape(s))
+}
 
 func makeResourceNode(node interface{}) (resourceNode, error) {
 	switch node := node.(type) {
@@ -296,37 +250,23 @@ func makeResourceNode(node interface{}) (resourceNode, error) {
 	return nil, fmt.Errorf("incompatible node type %T", node)
 }
 
-func configureResourceNode(node resourceNode, change types.ResourceChange, logicalResourceId string) {
-	var fillColor string
-	switch change.Replacement {
-	case types.ReplacementTrue:
-		fillColor = replacedResourceFillColor
-	case types.ReplacementConditional:
-		fillColor = maybeReplacedResourceFillColor
+func configureResourceNode(node resourceNode, r *Resource) {
+	if rc := r.ResourceChange(); rc != nil && r.Change.Type == types.ChangeTypeResource {
+		node.SetColors(resourceChangeColors(*rc))
 	}
-
-	var changeTypePrefix string
-	switch change.Action {
-	case types.ChangeActionAdd:
-		changeTypePrefix = "+"
-		node.SetColors(addedResourceColor, fillColor)
-	case types.ChangeActionRemove:
-		changeTypePrefix = "-"
-		node.SetColors(removedResourceColor, removedResourceFillColor)
-	case types.ChangeActionModify:
-		changeTypePrefix = "~"
-		node.SetColors(modifiedResourceColor, fillColor)
-	case types.ChangeActionImport:
-		changeTypePrefix = "*"
-		node.SetColors(importedResourceColor, fillColor)
-	case types.ChangeActionDynamic:
-		changeTypePrefix = "?"
-		node.SetColors(dynamicResourceColor, fillColor)
+	node.SetLabel(strings.Join(resourceLabel(r), "\n"))
+	if tooltip := resourceTooltip(r); tooltip != "" {
+		node.SetTooltip(tooltip)
+	}
+	if r.DriftedChange() {
+		node.SetPenWidth(driftedPenWidth)
+	}
+	if verdict, _ := r.StackPolicyVerdict(); verdict != "" {
+		node.SetShape(blockedResourceShape)
 	}
-	node.SetLabel(fmt.Sprintf("%s %s\n%s", changeTypePrefix, logicalResourceId, aws.ToString(change.ResourceType)))
 }
 
-func (csg *changeSetGraph) populateGraph(svc cloudformationClient, resp *cloudformation.DescribeChangeSetOutput) error {
+func (csg *changeSetGraph) populateGraph(stack *Stack) error {
 	// Nodes: 1. Resources that changed (name: StackName.LogicalResourceId)
 	//        2. Provided parameter (name: StackName.ParameterKey)
 	// Edges: 1. Cause-of-change
@@ -334,47 +274,17 @@ func (csg *changeSetGraph) populateGraph(svc cloudformationClient, resp *cloudfo
 	// nested stacks are subgraphs
 	// Coloring: resource/parameter (used/unused), cause
 
-	stackName := aws.ToString(resp.StackName)
-
-	pass2Changes := []types.Change{}
+	stackName := stack.Name
 
 	// Phase 1: Walk over the changes and build the nodes for all involved resources (as well as the sub-graphs for nested stacks)
-	for _, change := range resp.Changes {
-		logicalResourceId := aws.ToString(change.ResourceChange.LogicalResourceId)
+	for _, r := range stack.Changes() {
+		logicalResourceId := r.LogicalResourceId
+
 		// If this change is a nested stack we make up a "fake" node as root of the stack where we point to, and
 		// adjust the edges to point to the subgraph instead
-		resourceType := aws.ToString(change.ResourceChange.ResourceType)
-		isNestedStack := resourceType == "AWS::CloudFormation::Stack"
-
 		var node resourceNode
-		if isNestedStack {
-			log.Infof("processing %q nested stack %v.%v", change.ResourceChange.Action, stackName, logicalResourceId)
-
-			var nestedChangeSet *cloudformation.DescribeChangeSetOutput
-			var nestedStackName string
-			if change.ResourceChange.ChangeSetId != nil {
-				// Query the change set of that stack, which will also reveal the actual stack name
-				tmp, err := svc.DescribeChangeSet(context.TODO(), &cloudformation.DescribeChangeSetInput{
-					ChangeSetName: change.ResourceChange.ChangeSetId,
-				})
-				if err != nil {
-					return fmt.Errorf("failed to get changeset, %v", err)
-				}
-
-				// Prepare the graph for the stack
-				nestedChangeSet = tmp
-				nestedStackName = aws.ToString(nestedChangeSet.StackName)
-			} else {
-				// Parse the stack name out of the ARN
-				arn, err := arn.Parse(aws.ToString(change.ResourceChange.PhysicalResourceId))
-				if err != nil {
-					// Odd?
-					return fmt.Errorf("failed to parse physical resource id of nested stack as ARN, %v", err)
-				}
-				parts := strings.Split(arn.Resource, "/")
-				nestedStackName = parts[1]
-			}
-
+		if r.NestedStack != nil {
+			nestedStackName := r.NestedStack.Name
 			nestedGraph, err := csg.makeStack(stackName, nestedStackName, logicalResourceId)
 			if err != nil {
 				return fmt.Errorf("cannot make subgraph for nested stack change, %v", err)
@@ -382,8 +292,8 @@ func (csg *changeSetGraph) populateGraph(svc cloudformationClient, resp *cloudfo
 
 			// Populate the graph with everything going on inside that stack
 			// XXX: We could look at the template here if there is no changeset?
-			if nestedChangeSet != nil {
-				csg.populateGraph(svc, nestedChangeSet)
+			if err := csg.populateGraph(r.NestedStack); err != nil {
+				return err
 			}
 
 			clusterName := fmt.Sprintf("cluster_%s", nestedStackName)
@@ -397,7 +307,7 @@ func (csg *changeSetGraph) populateGraph(svc cloudformationClient, resp *cloudfo
 			changedNode.SetStyle("invis")
 
 			// Make this node also available through the original resource name
-			nodeName := csg.makeNodeId(stackName, logicalResourceId)
+			nodeName := makeNodeId(stackName, logicalResourceId)
 			existingNode, present := csg.nodes[nodeName]
 			if present {
 				// This should never happen. If it does: Try to hide the node, as we cannot remove a
@@ -423,118 +333,134 @@ func (csg *changeSetGraph) populateGraph(svc cloudformationClient, resp *cloudfo
 			}
 		}
 
-		if change.Type != types.ChangeTypeResource {
-			// We cannot handle these, someone needs to actually update the code.
-			node.SetLabel(fmt.Sprintf("%v", change.Type))
-			continue
+		configureResourceNode(node, r)
+		if r.NestedStack != nil && r.NestedStack.Err != nil {
+			// Make the failure visible on the cluster
+			node.SetColors(failedStackColor, failedStackFillColor)
 		}
+	}
 
-		configureResourceNode(node, *change.ResourceChange, logicalResourceId)
+	// Nodes standing in for changes left out by filters, so that the cause edges remain connected
+	for _, r := range stack.Resources {
+		if len(r.Elided) == 0 {
+			continue
+		}
+		elidedNode, err := csg.makeOrFindNode(stackName, r.LogicalResourceId, configureElidedNode)
+		if err != nil {
+			return fmt.Errorf("cannot make node for left out changes, %v", err)
+		}
+		node, err := makeResourceNode(elidedNode)
+		if err != nil {
+			return fmt.Errorf("cannot create resource node for node, %v", err)
+		}
+		configureResourceNode(node, r)
+	}
 
-		if len(change.ResourceChange.Details) > 0 {
-			pass2Changes = append(pass2Changes, change)
+	used, unused := csg.opts.splitParameters(stack)
+	if len(used) > 0 {
+		if _, err := csg.makeParametersNode(stack, used); err != nil {
+			return fmt.Errorf("cannot make parameters node, %v", err)
+		}
+	}
+	if len(unused) > 0 {
+		if _, err := csg.makeUnusedParametersNode(stack, unused); err != nil {
+			return fmt.Errorf("cannot make unused parameters node, %v", err)
+		}
+	}
+	if len(stack.ReferencedOutputs()) > 0 {
+		if _, err := csg.makeOutputsNode(stack); err != nil {
+			return fmt.Errorf("cannot make outputs node, %v", err)
 		}
 	}
 
 	// Phase 2: Build edges between nodes and the cause of their change
-	for _, change := range pass2Changes {
-		changeCauseNodes, err := csg.findChangeCauses(stackName, change.ResourceChange)
+	for _, cause := range stack.Causes {
+		changeCause, err := csg.findChangeCause(stack, cause)
 		if err != nil {
-			return fmt.Errorf("cannot find node for change cause (change: %v), %v", change, err)
+			return fmt.Errorf("cannot find node for change cause (change: %v), %v", cause.Changed.Id(), err)
 		}
 
-		logicalResourceId := aws.ToString(change.ResourceChange.LogicalResourceId)
+		logicalResourceId := cause.Changed.LogicalResourceId
 		changedNode, err := csg.findNode(stackName, logicalResourceId)
 		if err != nil {
 			return fmt.Errorf("cannot find changed node %s.%s", stackName, logicalResourceId)
 		}
 
-		for _, changeCause := range changeCauseNodes {
-			sourceNodeId := changeCause.node.Name()
-			var sourcePort string
-			if changeCause.port != nil {
-				sourcePort = *changeCause.port
-			}
-			targetNodeId := changedNode.Name()
-			targetName := aws.ToString(changeCause.detail.Target.Name)
+		sourceNodeId := changeCause.node.Name()
+		var sourcePort string
+		if changeCause.port != nil {
+			sourcePort = *changeCause.port
+		}
+		targetNodeId := changedNode.Name()
+		targetName := cause.TargetName
 
-			// Calculate a unique-enough name for this edge
-			edgeName := fmt.Sprintf(":%s_%s_%s", sourcePort, string(changeCause.detail.ChangeSource), targetName)
-			log.Infof("creating edge %q from %q to %q", edgeName, sourceNodeId, targetNodeId)
+		// Calculate a unique-enough name for this edge
+		edgeName := fmt.Sprintf(":%s_%s_%s", sourcePort, string(cause.Source), targetName)
+		log.Infof("creating edge %q from %q to %q", edgeName, sourceNodeId, targetNodeId)
 
-			e, err := csg.graphs[stackName].CreateEdge(edgeName, changeCause.node, changedNode)
-			if err != nil {
-				return fmt.Errorf("cannot make edge, %v", err)
-			}
+		e, err := csg.graphs[stackName].CreateEdge(edgeName, changeCause.node, changedNode)
+		if err != nil {
+			return fmt.Errorf("cannot make edge, %v", err)
+		}
 
-			if sourcePort != "" {
-				e.SetTailPort(sourcePort)
-			}
+		if sourcePort != "" {
+			e.SetTailPort(sourcePort)
+		}
 
-			switch changeCause.detail.Evaluation {
-			case types.EvaluationTypeStatic:
-				e.SetStyle(cgraph.SolidEdgeStyle)
-				e.SetTooltip("Static evaluation")
-			case types.EvaluationTypeDynamic:
-				e.SetStyle(cgraph.DashedEdgeStyle)
-				e.SetTooltip("Dynamic evaluation")
-			}
+		switch cause.Evaluation {
+		case types.EvaluationTypeStatic:
+			e.SetStyle(cgraph.SolidEdgeStyle)
+		case types.EvaluationTypeDynamic:
+			e.SetStyle(cgraph.DashedEdgeStyle)
+		}
+		if tooltip := causeEdgeTooltip(cause); tooltip != "" {
+			e.SetTooltip(tooltip)
+		}
+		// Point out the changes that force a replacement
+		if color, arrowHead := recreationEdgeStyle(cause); color != "" {
+			e.SetColor(color)
+			e.SetFontColor(color)
+			e.SetArrowHead(cgraph.ArrowType(arrowHead))
+			e.SetPenWidth(2)
+		}
 
-			// XXX: "Parameters" is pretty much the default for nested stacks, and just adds noise. But, is this check
-			//      enough, or could this now catch and hide user-defined things called
-			if changeCause.detail.Target.Attribute == types.ResourceAttributeProperties && targetName != "Parameters" {
-				e.SetHeadLabel(targetName)
-			} else if changeCause.detail.Target.Attribute != types.ResourceAttributeProperties {
-				e.SetHeadLabel(string(changeCause.detail.Target.Attribute))
-			}
+		if headLabel := causeTargetLabel(cause); headLabel != "" {
+			e.SetHeadLabel(headLabel)
+		}
 
-			// Show the source attribute
-			// XXX: This produces a mess because it might overlap with the port for where the cause of a change _to_ the thing
-			//      gets rendered, and we really want to see that part.
-			//      For nested stack outputs it might be helpful to build an Outputs record?
-			// if changeCause.detail.ChangeSource == types.ChangeSourceResourceAttribute {
-			// 	causingEntity := aws.ToString(changeCause.detail.CausingEntity)
-			// 	attributeName := causingEntity[strings.IndexByte(causingEntity, '.')+1:]
-			// 	e.SetTailLabel(attributeName)
-			// } // TODO: others? Note that Parameters have their box already.
-
-			causeClusterName := changeCause.node.Get("comment")
-			if causeClusterName != "" {
-				e.SetLogicalTail(causeClusterName)
-			}
-			changedClusterName := changedNode.Get("comment")
-			if changedClusterName != "" {
-				e.SetLogicalHead(changedClusterName)
-			}
+		// Show the source attribute
+		// XXX: This produces a mess because it might overlap with the port for where the cause of a change _to_ the thing
+		//      gets rendered, and we really want to see that part.
+		//      Outputs of nested stacks have their own record with ports, see makeOutputsNode.
+		// if cause.Source == types.ChangeSourceResourceAttribute {
+		// 	e.SetTailLabel(cause.SourceAttribute)
+		// } // TODO: others? Note that Parameters have their box already.
+
+		causeClusterName := changeCause.node.Get("comment")
+		if causeClusterName != "" {
+			e.SetLogicalTail(causeClusterName)
+		}
+		changedClusterName := changedNode.Get("comment")
+		if changedClusterName != "" {
+			e.SetLogicalHead(changedClusterName)
 		}
 	}
 
 	return nil
 }
 
-func NewChangeSetGraph(graph *cgraph.Graph, svc cloudformationClient, stackName string, rootChangeSetName string) (*changeSetGraph, error) {
-	// Build the request with its input parameters
-	params := &cloudformation.DescribeChangeSetInput{
-		ChangeSetName: aws.String(rootChangeSetName),
-	}
-	if stackName != "" {
-		params.StackName = aws.String(stackName)
-	}
-	resp, err := svc.DescribeChangeSet(context.TODO(), params)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get changeset, %v", err)
-	}
-
+// Render the changeset model into the graph
+func NewChangeSetGraph(graph *cgraph.Graph, model *ChangeSetModel, opts *GraphOpts) (*changeSetGraph, error) {
 	// We want subgraphs with logical heads
 	graph.SetCompound(true)
 
 	graphs := map[string]*cgraph.Graph{
-		aws.ToString(resp.StackName): graph,
+		model.Root.Name: graph,
 	}
 	nodes := map[string]*cgraph.Node{}
-	result := &changeSetGraph{graph, graphs, nodes}
+	result := &changeSetGraph{graph, graphs, nodes, opts}
 
-	err = result.populateGraph(svc, resp)
+	err := result.populateGraph(model.Root)
 	if err != nil {
 		return nil, err
 	}
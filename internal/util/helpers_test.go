@@ -0,0 +1,100 @@
+package util
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// A resource change with the given details
+func testChange(logicalResourceId string, resourceType string, action types.ChangeAction, replacement types.Replacement, details ...types.ResourceChangeDetail) types.Change {
+	rc := &types.ResourceChange{
+		Action:            action,
+		LogicalResourceId: aws.String(logicalResourceId),
+		ResourceType:      aws.String(resourceType),
+		Replacement:       replacement,
+		Details:           details,
+	}
+	if action == types.ChangeActionModify {
+		rc.Scope = []types.ResourceAttribute{types.ResourceAttributeProperties}
+	}
+	return types.Change{Type: types.ChangeTypeResource, ResourceChange: rc}
+}
+
+// The tree of a single stack with the given changes
+func testTree(stackName string, changes ...types.Change) *ChangeSetTree {
+	return &ChangeSetTree{
+		StackName: stackName,
+		ChangeSet: &cloudformation.DescribeChangeSetOutput{
+			StackName: aws.String(stackName),
+			Changes:   changes,
+		},
+	}
+}
+
+func newTestModel(t *testing.T, tree *ChangeSetTree) *ChangeSetModel {
+	t.Helper()
+	model, err := NewChangeSetModel(tree)
+	if err != nil {
+		t.Fatalf("cannot build model, %v", err)
+	}
+	return model
+}
+
+// A detail of a change to a property caused by a parameter
+func testParameterDetail(parameterKey string, property string) types.ResourceChangeDetail {
+	return types.ResourceChangeDetail{
+		ChangeSource:  types.ChangeSourceParameterReference,
+		CausingEntity: aws.String(parameterKey),
+		Evaluation:    types.EvaluationTypeStatic,
+		Target: &types.ResourceTargetDefinition{
+			Attribute: types.ResourceAttributeProperties,
+			Name:      aws.String(property),
+		},
+	}
+}
+
+// A detail of a change to a property caused by a reference to a resource
+func testResourceDetail(logicalResourceId string, property string) types.ResourceChangeDetail {
+	return types.ResourceChangeDetail{
+		ChangeSource:  types.ChangeSourceResourceReference,
+		CausingEntity: aws.String(logicalResourceId),
+		Evaluation:    types.EvaluationTypeStatic,
+		Target: &types.ResourceTargetDefinition{
+			Attribute: types.ResourceAttributeProperties,
+			Name:      aws.String(property),
+		},
+	}
+}
+
+// Add a nested stack with the given changes to the tree, and return the tree of the nested stack
+//
+// The changeset id of the nested stack is derived from its stack name, like the ids CloudFormation generates.
+func testNestedTree(parent *ChangeSetTree, logicalResourceId string, stackName string, changes ...types.Change) *ChangeSetTree {
+	nested := testTree(stackName, changes...)
+	nested.LogicalResourceId = logicalResourceId
+	nested.ChangeSet.ChangeSetId = aws.String(fmt.Sprintf("arn:aws:cloudformation:us-east-1:123456789012:changeSet/%s-cs/%s", stackName, stackName))
+
+	change := testChange(logicalResourceId, "AWS::CloudFormation::Stack", types.ChangeActionModify, types.ReplacementFalse)
+	change.ResourceChange.ChangeSetId = nested.ChangeSet.ChangeSetId
+	change.ResourceChange.PhysicalResourceId = aws.String(fmt.Sprintf("arn:aws:cloudformation:us-east-1:123456789012:stack/%s/%s", stackName, stackName))
+	parent.ChangeSet.Changes = append(parent.ChangeSet.Changes, change)
+	parent.Nested = append(parent.Nested, nested)
+	return nested
+}
+
+// A detail of a change to a property caused by an attribute of a resource, "Outputs.Name" for outputs of nested stacks
+func testAttributeDetail(logicalResourceId string, attribute string, property string) types.ResourceChangeDetail {
+	return types.ResourceChangeDetail{
+		ChangeSource:  types.ChangeSourceResourceAttribute,
+		CausingEntity: aws.String(logicalResourceId + "." + attribute),
+		Evaluation:    types.EvaluationTypeStatic,
+		Target: &types.ResourceTargetDefinition{
+			Attribute: types.ResourceAttributeProperties,
+			Name:      aws.String(property),
+		},
+	}
+}
@@ -0,0 +1,87 @@
+package util
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+//go:embed templates/report.html.tmpl
+var htmlReportTemplateSource string
+
+var htmlReportTemplate = template.Must(template.New("report").Parse(htmlReportTemplateSource))
+
+// Information shown in the side panel for a node
+type htmlNodeInfo struct {
+	StackName      string
+	Type           types.ChangeType      `json:",omitempty"`
+	ResourceChange *types.ResourceChange `json:",omitempty"`
+	Causes         []string              `json:",omitempty"`
+	Hooks          []string              `json:",omitempty"`
+	Properties     []string              `json:",omitempty"`
+	Fate           string                `json:",omitempty"`
+	StackPolicy    string                `json:",omitempty"`
+	Drift          []string              `json:",omitempty"`
+	Error          string                `json:",omitempty"`
+}
+
+type htmlReportData struct {
+	Title string
+	SVG   template.HTML
+	Nodes map[string]htmlNodeInfo
+}
+
+// Write a self-contained HTML page with the rendered SVG graph of the changeset
+//
+// Nodes in the SVG are expected to use the ids of the resources as element ids, so that the page can show the
+// resource change when clicking a node.
+func WriteHTMLReport(out io.Writer, model *ChangeSetModel, svg []byte) error {
+	// Strip the XML prolog and doctype, the SVG gets inlined into the HTML document
+	start := bytes.Index(svg, []byte("<svg"))
+	if start < 0 {
+		return fmt.Errorf("cannot find SVG element in rendered graph")
+	}
+
+	data := htmlReportData{
+		Title: model.Root.Name,
+		SVG:   template.HTML(svg[start:]),
+		Nodes: map[string]htmlNodeInfo{},
+	}
+	if model.Root.ChangeSet != nil && model.Root.ChangeSet.ChangeSetName != nil {
+		data.Title = fmt.Sprintf("%s (%s)", *model.Root.ChangeSet.ChangeSetName, model.Root.Name)
+	}
+	model.Root.Walk(func(stack *Stack) error {
+		for _, r := range stack.Resources {
+			info := htmlNodeInfo{StackName: stack.Name}
+			if r.Change != nil {
+				info.Type = r.Change.Type
+				info.ResourceChange = r.Change.ResourceChange
+			}
+			if r.NestedStack != nil && r.NestedStack.Err != nil {
+				info.Error = r.NestedStack.Err.Error()
+			}
+			info.Hooks = r.HookDescriptions()
+			info.Fate = r.FateDescription()
+			if verdict, reason := r.StackPolicyVerdict(); verdict != "" {
+				info.StackPolicy = fmt.Sprintf("%s: %s", verdict, reason)
+			}
+			for _, c := range r.PropertyChanges() {
+				info.Properties = append(info.Properties, c.String())
+			}
+			if r.DriftedChange() {
+				info.Drift = r.DriftDescriptions()
+			}
+			for _, cause := range r.Causes {
+				info.Causes = append(info.Causes, DescribeChangeDetail(cause.Detail))
+			}
+			data.Nodes[r.Id()] = info
+		}
+		return nil
+	})
+
+	return htmlReportTemplate.Execute(out, data)
+}
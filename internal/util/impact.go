@@ -0,0 +1,172 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A change reached when following the cause edges from a parameter or resource
+type ImpactedChange struct {
+	Resource *Resource
+	// Number of steps between the origin and this change: cause edges, and passing parameters into nested stacks
+	Depth int
+	// The cause edge through which the change was reached first, nil for changes of the origin itself
+	Via *Cause
+}
+
+// The changes caused by a parameter or a resource, directly and transitively
+type Impact struct {
+	// The origin, either a parameter or a resource
+	Parameter *Parameter
+	Resource  *Resource
+
+	// The affected changes, in the order in which they were reached
+	Changes []*ImpactedChange
+	// All followed cause edges
+	Causes []*Cause
+}
+
+type impactSearch struct {
+	impact *Impact
+
+	seenParameters map[*Parameter]bool
+	seenResources  map[*Resource]bool
+	seenCauses     map[*Cause]bool
+
+	// Work list of parameters and resources to expand, in breadth-first order
+	queue []impactQueueEntry
+}
+
+type impactQueueEntry struct {
+	parameter *Parameter
+	resource  *Resource
+	depth     int
+}
+
+func (s *impactSearch) addParameter(p *Parameter, depth int) {
+	if s.seenParameters[p] {
+		return
+	}
+	s.seenParameters[p] = true
+	s.queue = append(s.queue, impactQueueEntry{parameter: p, depth: depth})
+}
+
+func (s *impactSearch) addResource(r *Resource, depth int, via *Cause) {
+	if s.seenResources[r] {
+		return
+	}
+	s.seenResources[r] = true
+	if r.Change != nil {
+		s.impact.Changes = append(s.impact.Changes, &ImpactedChange{Resource: r, Depth: depth, Via: via})
+	}
+	s.queue = append(s.queue, impactQueueEntry{resource: r, depth: depth})
+}
+
+func (s *impactSearch) follow(cause *Cause, depth int) {
+	if !s.seenCauses[cause] {
+		s.seenCauses[cause] = true
+		s.impact.Causes = append(s.impact.Causes, cause)
+	}
+	s.addResource(cause.Changed, depth, cause)
+}
+
+func (s *impactSearch) expandResource(r *Resource, depth int) {
+	for _, cause := range r.Effects {
+		s.follow(cause, depth+1)
+	}
+
+	// Into a nested stack: The changeset only says that the parameters of the stack change, but not which ones, so
+	// assume that all parameters of the nested stack that cause changes are affected. Passing the parameters is a
+	// step of its own.
+	if r.NestedStack != nil {
+		for _, p := range r.NestedStack.UsedParameters() {
+			s.addParameter(p, depth+1)
+		}
+	}
+
+	// Out of a nested stack: Changes inside the stack can change its outputs, and with that everything using them
+	// in the parent stack. The changeset does not say which outputs depend on which changes, so all outputs are
+	// followed.
+	if r.Change != nil && r.Stack.Parent != nil {
+		if stackResource := r.Stack.Parent.FindResource(r.Stack.LogicalResourceId); stackResource != nil {
+			for _, cause := range stackResource.Effects {
+				if strings.HasPrefix(cause.SourceAttribute, "Outputs.") {
+					s.follow(cause, depth+1)
+				}
+			}
+		}
+	}
+}
+
+// Find a parameter ("StackName.ParameterKey" or "StackName.Parameters.ParameterKey") or resource
+// ("StackName.LogicalResourceId")
+func (m *ChangeSetModel) FindEntity(id string) (*Parameter, *Resource, error) {
+	stackName, name, found := strings.Cut(id, ".")
+	if !found || name == "" {
+		return nil, nil, fmt.Errorf("invalid id %q, must be StackName.ParameterKey or StackName.LogicalResourceId", id)
+	}
+	stack, present := m.Stacks[stackName]
+	if !present {
+		return nil, nil, fmt.Errorf("cannot find stack %q", stackName)
+	}
+	if strings.HasPrefix(name, parametersNodeName+".") {
+		if p := stack.FindParameter(strings.TrimPrefix(name, parametersNodeName+".")); p != nil {
+			return p, nil, nil
+		}
+	}
+	if r := stack.FindResource(name); r != nil {
+		return nil, r, nil
+	}
+	if p := stack.FindParameter(name); p != nil {
+		return p, nil, nil
+	}
+	return nil, nil, fmt.Errorf("cannot find parameter or resource %q in stack %q", name, stackName)
+}
+
+// Compute the changes caused by the parameter or resource with the given id, see `FindEntity`
+//
+// This follows the cause edges, into nested stacks through their parameters, and out of nested stacks through their
+// outputs. As the changeset does not say which nested stack parameters depend on which outputs, crossing stack
+// boundaries is conservative and may include more changes than will actually happen.
+func (m *ChangeSetModel) Impact(id string) (*Impact, error) {
+	p, r, err := m.FindEntity(id)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &impactSearch{
+		impact:         &Impact{Parameter: p, Resource: r, Changes: []*ImpactedChange{}, Causes: []*Cause{}},
+		seenParameters: map[*Parameter]bool{},
+		seenResources:  map[*Resource]bool{},
+		seenCauses:     map[*Cause]bool{},
+	}
+	if p != nil {
+		s.addParameter(p, 0)
+	} else {
+		s.addResource(r, 0, nil)
+	}
+	for len(s.queue) > 0 {
+		entry := s.queue[0]
+		s.queue = s.queue[1:]
+		if entry.parameter != nil {
+			for _, cause := range entry.parameter.Effects {
+				s.follow(cause, entry.depth+1)
+			}
+		} else {
+			s.expandResource(entry.resource, entry.depth)
+		}
+	}
+	return s.impact, nil
+}
+
+// The resources involved in the impact, including the origin if it is a resource
+func (impact *Impact) Resources() []*Resource {
+	result := []*Resource{}
+	if impact.Resource != nil {
+		result = append(result, impact.Resource)
+	}
+	for _, change := range impact.Changes {
+		result = append(result, change.Resource)
+	}
+	return result
+}
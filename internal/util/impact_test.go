@@ -0,0 +1,92 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// Root.Cidr -> Network (nested stack) -> Network.Cidr -> Vpc -> Network.Outputs.VpcId -> Instance, and
+// Root.InstanceType -> Instance
+func testImpactModel(t *testing.T) *ChangeSetModel {
+	tree := testTree("Root",
+		testChange("Instance", "AWS::EC2::Instance", types.ChangeActionModify, types.ReplacementTrue, testParameterDetail("InstanceType", "InstanceType"), testAttributeDetail("Network", "Outputs.VpcId", "SubnetId")),
+		testChange("Topic", "AWS::SNS::Topic", types.ChangeActionAdd, ""),
+	)
+	testNestedTree(tree, "Network", "Root-Network-AAAA",
+		testChange("Vpc", "AWS::EC2::VPC", types.ChangeActionModify, types.ReplacementTrue, testParameterDetail("Cidr", "CidrBlock")),
+		testChange("Queue", "AWS::SQS::Queue", types.ChangeActionModify, types.ReplacementFalse, testParameterDetail("Environment", "Tags")),
+	)
+	network := tree.ChangeSet.Changes[len(tree.ChangeSet.Changes)-1]
+	network.ResourceChange.Details = append(network.ResourceChange.Details, testParameterDetail("Cidr", "Parameters"))
+	return newTestModel(t, tree)
+}
+
+func TestImpact(t *testing.T) {
+	tests := []struct {
+		from string
+		// "Depth Id <- Via" for each change, in the order they are reached
+		want    []string
+		wantErr string
+	}{
+		{
+			from: "Root.InstanceType",
+			want: []string{"1 Root.Instance <- Root.Parameters.InstanceType"},
+		},
+		{
+			from: "Root.Parameters.Cidr",
+			want: []string{
+				"1 Root.Network <- Root.Parameters.Cidr",
+				"2 Root.Instance <- Root.Network.Outputs.VpcId",
+				"3 Root-Network-AAAA.Vpc <- Root-Network-AAAA.Parameters.Cidr",
+				"3 Root-Network-AAAA.Queue <- Root-Network-AAAA.Parameters.Environment",
+			},
+		},
+		{
+			from: "Root-Network-AAAA.Vpc",
+			want: []string{
+				"0 Root-Network-AAAA.Vpc <- ",
+				"1 Root.Instance <- Root.Network.Outputs.VpcId",
+			},
+		},
+		{
+			from: "Root-Network-AAAA.Cidr",
+			want: []string{
+				"1 Root-Network-AAAA.Vpc <- Root-Network-AAAA.Parameters.Cidr",
+				"2 Root.Instance <- Root.Network.Outputs.VpcId",
+			},
+		},
+		{from: "Root.Topic", want: []string{"0 Root.Topic <- "}},
+		{from: "Root", wantErr: "invalid id"},
+		{from: "Other.Cidr", wantErr: "cannot find stack"},
+		{from: "Root.Missing", wantErr: "cannot find parameter or resource"},
+	}
+	model := testImpactModel(t)
+	for _, tt := range tests {
+		t.Run(tt.from, func(t *testing.T) {
+			impact, err := model.Impact(tt.from)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Impact() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Impact() error = %v", err)
+			}
+			got := []string{}
+			for _, change := range impact.Changes {
+				via := ""
+				if change.Via != nil {
+					via = change.Via.SourceId()
+				}
+				got = append(got, fmt.Sprintf("%d %s <- %s", change.Depth, change.Resource.Id(), via))
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("Impact() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
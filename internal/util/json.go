@@ -0,0 +1,264 @@
+package util
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+type jsonDocument struct {
+	ChangeSetName string     `json:"changeSetName,omitempty"`
+	ChangeSetId   string     `json:"changeSetId,omitempty"`
+	Stack         *jsonStack `json:"stack"`
+}
+
+type jsonStack struct {
+	StackName     string `json:"stackName"`
+	StackId       string `json:"stackId,omitempty"`
+	ChangeSetName string `json:"changeSetName,omitempty"`
+	ChangeSetId   string `json:"changeSetId,omitempty"`
+	Status        string `json:"status,omitempty"`
+	Error         string `json:"error,omitempty"`
+
+	Parameters []*jsonParameter `json:"parameters"`
+	Resources  []*jsonResource  `json:"resources"`
+	Causes     []*jsonCause     `json:"causes"`
+	Hooks      []*jsonHook      `json:"hooks,omitempty"`
+}
+
+type jsonParameter struct {
+	Id    string `json:"id"`
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+	Used  bool   `json:"used"`
+	// The value in the currently deployed stack, only known when the current stacks were fetched
+	PreviousValue string `json:"previousValue,omitempty"`
+	Changed       bool   `json:"changed,omitempty"`
+	NoEcho        bool   `json:"noEcho,omitempty"`
+}
+
+type jsonResource struct {
+	Id                 string   `json:"id"`
+	LogicalResourceId  string   `json:"logicalResourceId"`
+	PhysicalResourceId string   `json:"physicalResourceId,omitempty"`
+	ResourceType       string   `json:"resourceType,omitempty"`
+	ChangeType         string   `json:"changeType,omitempty"`
+	Action             string   `json:"action,omitempty"`
+	Replacement        string   `json:"replacement,omitempty"`
+	Scope              []string `json:"scope,omitempty"`
+	// Number of hook invocations announced for the change
+	HookInvocationCount int `json:"hookInvocationCount,omitempty"`
+	// Values of the changed properties, only known when the templates were fetched
+	PropertyChanges []*jsonPropertyChange `json:"propertyChanges,omitempty"`
+	// What happens to a removed or replaced resource, only known when the templates were fetched
+	Fate string `json:"fate,omitempty"`
+	// Prediction of the stack policy, only known when the stack policies were fetched
+	StackPolicy *jsonStackPolicyVerdict `json:"stackPolicy,omitempty"`
+	// Drift of the deployed resource, only known when the drift was checked
+	Drift *jsonDrift `json:"drift,omitempty"`
+
+	NestedStack *jsonStack `json:"nestedStack,omitempty"`
+}
+
+type jsonTarget struct {
+	Attribute           string `json:"attribute,omitempty"`
+	Name                string `json:"name,omitempty"`
+	Path                string `json:"path,omitempty"`
+	RequiresRecreation  string `json:"requiresRecreation,omitempty"`
+	AttributeChangeType string `json:"attributeChangeType,omitempty"`
+	BeforeValue         string `json:"beforeValue,omitempty"`
+	AfterValue          string `json:"afterValue,omitempty"`
+}
+
+type jsonCause struct {
+	Id            string     `json:"id"`
+	ChangeSource  string     `json:"changeSource"`
+	Evaluation    string     `json:"evaluation,omitempty"`
+	CausingEntity string     `json:"causingEntity,omitempty"`
+	From          string     `json:"from,omitempty"`
+	FromAttribute string     `json:"fromAttribute,omitempty"`
+	To            string     `json:"to"`
+	Target        jsonTarget `json:"target"`
+}
+
+type jsonPropertyChange struct {
+	Name string `json:"name"`
+	// Missing when the property is not set
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+type jsonStackPolicyVerdict struct {
+	Verdict string `json:"verdict"`
+	Reason  string `json:"reason"`
+}
+
+type jsonDrift struct {
+	Status              string                    `json:"status"`
+	PropertyDifferences []*jsonPropertyDifference `json:"propertyDifferences,omitempty"`
+}
+
+type jsonPropertyDifference struct {
+	PropertyPath   string `json:"propertyPath"`
+	ExpectedValue  string `json:"expectedValue"`
+	ActualValue    string `json:"actualValue"`
+	DifferenceType string `json:"differenceType"`
+}
+
+type jsonHook struct {
+	TypeName        string `json:"typeName"`
+	FailureMode     string `json:"failureMode,omitempty"`
+	InvocationPoint string `json:"invocationPoint,omitempty"`
+	TargetType      string `json:"targetType,omitempty"`
+	// The id of the target resource, if known
+	Target         string `json:"target,omitempty"`
+	ResourceAction string `json:"resourceAction,omitempty"`
+	ResourceType   string `json:"resourceType,omitempty"`
+}
+
+func makeJSONResource(r *Resource) *jsonResource {
+	result := &jsonResource{
+		Id:                r.Id(),
+		LogicalResourceId: r.LogicalResourceId,
+	}
+	if r.Change != nil {
+		result.ChangeType = string(r.Change.Type)
+		result.HookInvocationCount = r.HookInvocationCount()
+	}
+	for _, c := range r.PropertyChanges() {
+		result.PropertyChanges = append(result.PropertyChanges, &jsonPropertyChange{Name: c.Name, Before: c.Before, After: c.After})
+	}
+	result.Fate = string(r.Fate())
+	if verdict, reason := r.StackPolicyVerdict(); verdict != "" {
+		result.StackPolicy = &jsonStackPolicyVerdict{Verdict: string(verdict), Reason: reason}
+	}
+	if r.Drift != nil {
+		result.Drift = &jsonDrift{Status: string(r.Drift.StackResourceDriftStatus)}
+		for _, difference := range r.Drift.PropertyDifferences {
+			result.Drift.PropertyDifferences = append(result.Drift.PropertyDifferences, &jsonPropertyDifference{
+				PropertyPath:   aws.ToString(difference.PropertyPath),
+				ExpectedValue:  aws.ToString(difference.ExpectedValue),
+				ActualValue:    aws.ToString(difference.ActualValue),
+				DifferenceType: string(difference.DifferenceType),
+			})
+		}
+	}
+	if rc := r.ResourceChange(); rc != nil {
+		result.PhysicalResourceId = aws.ToString(rc.PhysicalResourceId)
+		result.ResourceType = aws.ToString(rc.ResourceType)
+		result.Action = string(rc.Action)
+		result.Replacement = string(rc.Replacement)
+		for _, scope := range rc.Scope {
+			result.Scope = append(result.Scope, string(scope))
+		}
+	}
+	if r.NestedStack != nil {
+		result.NestedStack = makeJSONStack(r.NestedStack)
+	}
+	return result
+}
+
+func makeJSONCause(cause *Cause) *jsonCause {
+	result := &jsonCause{
+		Id:            cause.Id(),
+		ChangeSource:  string(cause.Source),
+		Evaluation:    string(cause.Evaluation),
+		CausingEntity: aws.ToString(cause.Detail.CausingEntity),
+		FromAttribute: cause.SourceAttribute,
+		To:            cause.Changed.Id(),
+		Target: jsonTarget{
+			Attribute:          string(cause.TargetAttribute),
+			Name:               cause.TargetName,
+			RequiresRecreation: string(cause.RequiresRecreation),
+		},
+	}
+	if target := cause.Detail.Target; target != nil {
+		result.Target.Path = aws.ToString(target.Path)
+		result.Target.AttributeChangeType = string(target.AttributeChangeType)
+		result.Target.BeforeValue = aws.ToString(target.BeforeValue)
+		result.Target.AfterValue = aws.ToString(target.AfterValue)
+	}
+	if cause.Parameter != nil {
+		result.From = cause.Parameter.Id()
+	} else if cause.Resource != nil {
+		result.From = cause.Resource.Id()
+	}
+	return result
+}
+
+func makeJSONStack(stack *Stack) *jsonStack {
+	result := &jsonStack{
+		StackName:  stack.Name,
+		Parameters: []*jsonParameter{},
+		Resources:  []*jsonResource{},
+		Causes:     []*jsonCause{},
+	}
+	if stack.Err != nil {
+		result.Error = stack.Err.Error()
+	}
+	if stack.ChangeSet != nil {
+		result.StackId = aws.ToString(stack.ChangeSet.StackId)
+		result.ChangeSetName = aws.ToString(stack.ChangeSet.ChangeSetName)
+		result.ChangeSetId = aws.ToString(stack.ChangeSet.ChangeSetId)
+		result.Status = string(stack.ChangeSet.Status)
+	}
+	for _, p := range stack.Parameters {
+		parameter := &jsonParameter{
+			Id:            p.Id(),
+			Name:          p.Name,
+			Value:         p.Value,
+			Used:          len(p.Effects) > 0,
+			PreviousValue: p.PreviousValue,
+			Changed:       p.Changed(),
+			NoEcho:        p.NoEcho,
+		}
+		if p.NoEcho {
+			parameter.Value = maskedParameterValue
+			if p.HasPreviousValue {
+				parameter.PreviousValue = maskedParameterValue
+			}
+		}
+		result.Parameters = append(result.Parameters, parameter)
+	}
+	for _, r := range stack.Resources {
+		result.Resources = append(result.Resources, makeJSONResource(r))
+	}
+	for _, cause := range stack.Causes {
+		result.Causes = append(result.Causes, makeJSONCause(cause))
+	}
+	for _, hook := range stack.Hooks {
+		h := &jsonHook{
+			TypeName:        hook.TypeName,
+			FailureMode:     string(hook.FailureMode),
+			InvocationPoint: string(hook.InvocationPoint),
+			TargetType:      string(hook.TargetType),
+			ResourceAction:  string(hook.ResourceAction),
+			ResourceType:    hook.ResourceType,
+		}
+		if hook.Resource != nil {
+			h.Target = hook.Resource.Id()
+		}
+		result.Hooks = append(result.Hooks, h)
+	}
+	return result
+}
+
+// Write the whole changeset model as a single JSON document
+//
+// Nested stacks are inlined into the stack resource of their parent stack. Parameters, resources and cause
+// edges carry stable ids of the form "StackName.LogicalResourceId" ("StackName.Parameters.ParameterKey" for
+// parameters).
+func WriteJSONReport(out io.Writer, model *ChangeSetModel) error {
+	doc := &jsonDocument{
+		Stack: makeJSONStack(model.Root),
+	}
+	if model.Root.ChangeSet != nil {
+		doc.ChangeSetName = aws.ToString(model.Root.ChangeSet.ChangeSetName)
+		doc.ChangeSetId = aws.ToString(model.Root.ChangeSet.ChangeSetId)
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(doc)
+}
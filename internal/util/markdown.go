@@ -0,0 +1,345 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+type markdownWriter struct {
+	*bufio.Writer
+}
+
+func escapeMarkdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", "\\|")
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	return fmt.Sprintf("`%s`", s)
+}
+
+func (w *markdownWriter) writeTable(header []string, rows [][]string) {
+	fmt.Fprintf(w, "| %s |\n", strings.Join(header, " | "))
+	fmt.Fprintf(w, "|%s\n", strings.Repeat(" --- |", len(header)))
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = escapeMarkdownCell(cell)
+		}
+		fmt.Fprintf(w, "| %s |\n", strings.Join(cells, " | "))
+	}
+	fmt.Fprintln(w)
+}
+
+func changedTargets(rc *types.ResourceChange) string {
+	targets := []string{}
+	for _, detail := range rc.Details {
+		if detail.Target == nil {
+			continue
+		}
+		target := string(detail.Target.Attribute)
+		if name := aws.ToString(detail.Target.Name); name != "" {
+			target = fmt.Sprintf("%s.%s", target, name)
+		}
+		target = markdownCode(target)
+		if !contains(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+	return strings.Join(targets, ", ")
+}
+
+func scopeString(rc *types.ResourceChange) string {
+	scope := make([]string, 0, len(rc.Scope))
+	for _, s := range rc.Scope {
+		scope = append(scope, string(s))
+	}
+	return strings.Join(scope, ", ")
+}
+
+func (w *markdownWriter) writeResourceSection(title string, resources []*Resource, withDetails bool) {
+	if len(resources) == 0 {
+		return
+	}
+
+	// The fate is only known for removed and replaced resources, and only when the templates are known
+	withFate := false
+	for _, r := range resources {
+		withFate = withFate || r.Fate() != ""
+	}
+
+	fmt.Fprintf(w, "**%s**\n\n", title)
+	header := []string{"Logical ID", "Type", "Physical ID"}
+	if withDetails {
+		header = append(header, "Replacement", "Scope", "Changed")
+	}
+	if withFate {
+		header = append(header, "Fate")
+	}
+	rows := [][]string{}
+	for _, r := range resources {
+		rc := r.ResourceChange()
+		row := []string{
+			markdownCode(aws.ToString(rc.LogicalResourceId)),
+			aws.ToString(rc.ResourceType),
+			markdownCode(aws.ToString(rc.PhysicalResourceId)),
+		}
+		if withDetails {
+			row = append(row, string(rc.Replacement), scopeString(rc), changedTargets(rc))
+		}
+		if withFate {
+			row = append(row, r.FateDescription())
+		}
+		rows = append(rows, row)
+	}
+	w.writeTable(header, rows)
+}
+
+func (w *markdownWriter) writeParameterCauses(stack *Stack) {
+	parameters := stack.UsedParameters()
+	if len(parameters) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "**Changes caused by parameters**\n\n")
+	for _, parameter := range parameters {
+		causedChanges := []string{}
+		for _, cause := range parameter.Effects {
+			caused := markdownCode(cause.Changed.LogicalResourceId)
+			if cause.TargetAttribute != "" {
+				caused = fmt.Sprintf("%s (%s, %s)", caused, markdownCode(cause.TargetPath()), cause.Evaluation)
+			}
+			if !contains(causedChanges, caused) {
+				causedChanges = append(causedChanges, caused)
+			}
+		}
+		fmt.Fprintf(w, "* %s: %s\n", markdownCode(parameter.Name), strings.Join(causedChanges, ", "))
+	}
+	fmt.Fprintln(w)
+}
+
+func (w *markdownWriter) writeParameterChanges(stack *Stack) {
+	if stack.CurrentStack == nil {
+		return
+	}
+
+	rows := [][]string{}
+	for _, parameter := range stack.Parameters {
+		// Whether the value of a NoEcho parameter changes is unknown, only list the ones that cause changes
+		if parameter.NoEcho && len(parameter.Effects) == 0 {
+			continue
+		}
+		if !parameter.Changed() && !parameter.NoEcho {
+			continue
+		}
+		previous, value := "_(not set)_", markdownCode(parameter.Value)
+		if parameter.HasPreviousValue {
+			previous = markdownCode(parameter.PreviousValue)
+		}
+		if parameter.NoEcho {
+			previous, value = markdownCode(maskedParameterValue), markdownCode(maskedParameterValue)
+		}
+		causesChanges := "yes"
+		if len(parameter.Effects) == 0 {
+			causesChanges = "**no**"
+		}
+		rows = append(rows, []string{markdownCode(parameter.Name), previous, value, causesChanges})
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "**Parameter changes**\n\n")
+	w.writeTable([]string{"Parameter", "Deployed value", "New value", "Causes changes"}, rows)
+}
+
+func (w *markdownWriter) writePropertyChanges(stack *Stack) {
+	lines := []string{}
+	for _, r := range stack.Changes() {
+		for _, c := range r.PropertyChanges() {
+			if c.SameValue() {
+				lines = append(lines, fmt.Sprintf("* %s %s: %s (value unchanged)", markdownCode(r.LogicalResourceId), markdownCode(c.Name), markdownCode(FormatTemplateValue(c.After))))
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("* %s %s: %s -> %s", markdownCode(r.LogicalResourceId), markdownCode(c.Name), markdownCode(formatPropertyValue(c.Before, c.BeforeSet)), markdownCode(formatPropertyValue(c.After, c.AfterSet))))
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "**Property changes**\n\n")
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintln(w)
+}
+
+func (w *markdownWriter) writeDriftedChanges(stack *Stack) {
+	rows := [][]string{}
+	for _, r := range stack.Changes() {
+		if !r.DriftedChange() {
+			continue
+		}
+		rows = append(rows, []string{
+			markdownCode(r.LogicalResourceId),
+			string(r.ResourceChange().Replacement),
+			string(r.Drift.StackResourceDriftStatus),
+			strings.Join(r.DriftDescriptions(), "<br>"),
+		})
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "**Drifted resources**\n\n")
+	fmt.Fprintf(w, "_These resources drifted from their template, the changeset does not account for the actual values._\n\n")
+	w.writeTable([]string{"Resource", "Replacement", "Drift", "Differences"}, rows)
+}
+
+func (w *markdownWriter) writeBlockedChanges(stack *Stack) {
+	rows := [][]string{}
+	for _, r := range stack.Changes() {
+		verdict, reason := r.StackPolicyVerdict()
+		if verdict == "" {
+			continue
+		}
+		rc := r.ResourceChange()
+		rows = append(rows, []string{
+			markdownCode(r.LogicalResourceId),
+			aws.ToString(rc.ResourceType),
+			string(rc.Action),
+			string(rc.Replacement),
+			fmt.Sprintf("**%s**", verdict),
+			reason,
+		})
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "**Blocked by stack policy**\n\n")
+	w.writeTable([]string{"Resource", "Type", "Action", "Replacement", "Prediction", "Reason"}, rows)
+}
+
+func (w *markdownWriter) writeHooks(stack *Stack) {
+	rows := [][]string{}
+	for _, hook := range stack.Hooks {
+		target := ""
+		if hook.Resource != nil {
+			target = markdownCode(hook.Resource.LogicalResourceId)
+		}
+		rows = append(rows, []string{
+			markdownCode(hook.TypeName),
+			string(hook.TargetType),
+			target,
+			string(hook.ResourceAction),
+			string(hook.FailureMode),
+			string(hook.InvocationPoint),
+		})
+	}
+	// Changes that announce hook invocations, but for which the hooks could not be described
+	for _, r := range stack.Changes() {
+		if len(r.Hooks) == 0 && r.HookInvocationCount() > 0 {
+			rows = append(rows, []string{
+				fmt.Sprintf("_%d invocation(s)_", r.HookInvocationCount()),
+				"",
+				markdownCode(r.LogicalResourceId),
+				string(r.ResourceChange().Action),
+				"",
+				"",
+			})
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "**Hooks**\n\n")
+	w.writeTable([]string{"Hook", "Target type", "Target", "Action", "Failure mode", "Invocation point"}, rows)
+}
+
+func (w *markdownWriter) writeStack(stack *Stack, depth int) {
+	headingLevel := 2 + depth
+	if headingLevel > 6 {
+		headingLevel = 6
+	}
+	fmt.Fprintf(w, "%s Stack %s\n\n", strings.Repeat("#", headingLevel), markdownCode(stack.Name))
+
+	if stack.Err != nil {
+		fmt.Fprintf(w, "> **Error:** %s\n\n", escapeMarkdownCell(stack.Err.Error()))
+		return
+	}
+	if stack.ChangeSet == nil {
+		fmt.Fprintf(w, "_No changeset available for this stack._\n\n")
+		return
+	}
+
+	var added, removed, modified, replaced, other []*Resource
+	for _, r := range stack.Changes() {
+		switch r.ResourceChange().Action {
+		case types.ChangeActionAdd:
+			added = append(added, r)
+		case types.ChangeActionRemove:
+			removed = append(removed, r)
+		case types.ChangeActionModify:
+			if r.Replaced() {
+				replaced = append(replaced, r)
+			} else {
+				modified = append(modified, r)
+			}
+		default:
+			other = append(other, r)
+		}
+	}
+
+	if len(added)+len(removed)+len(modified)+len(replaced)+len(other) == 0 {
+		fmt.Fprintf(w, "_No resource changes._\n\n")
+	}
+	w.writeResourceSection("Added resources", added, false)
+	w.writeResourceSection("Removed resources", removed, false)
+	w.writeResourceSection("Replaced resources", replaced, true)
+	w.writeResourceSection("Modified resources", modified, true)
+	w.writeResourceSection("Other changes", other, true)
+	w.writeParameterChanges(stack)
+	w.writeParameterCauses(stack)
+	w.writePropertyChanges(stack)
+	w.writeDriftedChanges(stack)
+	w.writeBlockedChanges(stack)
+	w.writeHooks(stack)
+
+	for _, nested := range stack.Nested {
+		failed := ""
+		if nested.Err != nil {
+			failed = " :x: failed"
+		}
+		fmt.Fprintf(w, "<details>\n<summary>Nested stack <code>%s</code> (<code>%s</code>)%s</summary>\n\n", nested.LogicalResourceId, nested.Name, failed)
+		w.writeStack(nested, depth+1)
+		fmt.Fprintf(w, "</details>\n\n")
+	}
+}
+
+// Write a Markdown review report for the changeset
+//
+// The report contains a section for each stack, with nested stacks collapsed into `<details>` blocks.
+func WriteMarkdownReport(out io.Writer, model *ChangeSetModel) error {
+	w := &markdownWriter{bufio.NewWriter(out)}
+
+	root := model.Root
+	title := root.Name
+	if root.ChangeSet != nil {
+		title = fmt.Sprintf("%s for stack %s", markdownCode(aws.ToString(root.ChangeSet.ChangeSetName)), markdownCode(root.Name))
+	}
+	fmt.Fprintf(w, "# Changeset %s\n\n", title)
+	w.writeStack(root, 0)
+
+	return w.Flush()
+}
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func TestWriteMarkdownReportParameterChanges(t *testing.T) {
+	tree := testTree("Stack",
+		testChange("Instance", "AWS::EC2::Instance", types.ChangeActionModify, types.ReplacementTrue, testParameterDetail("InstanceType", "InstanceType"), testParameterDetail("Password", "UserData")),
+	)
+	parameters := map[string][2]string{
+		// Key: deployed and new value
+		"InstanceType": {"t3.micro", "t3.small"},
+		"Purpose":      {"test", "production"},
+		"Environment":  {"prod", "prod"},
+		"Password":     {"****", "****"},
+		"ApiKey":       {"****", "****"},
+	}
+	tree.CurrentStack = &types.Stack{StackName: aws.String("Stack")}
+	for _, key := range []string{"InstanceType", "Purpose", "Environment", "Password", "ApiKey"} {
+		tree.ChangeSet.Parameters = append(tree.ChangeSet.Parameters, types.Parameter{ParameterKey: aws.String(key), ParameterValue: aws.String(parameters[key][1])})
+		tree.CurrentStack.Parameters = append(tree.CurrentStack.Parameters, types.Parameter{ParameterKey: aws.String(key), ParameterValue: aws.String(parameters[key][0])})
+	}
+
+	var out bytes.Buffer
+	if err := WriteMarkdownReport(&out, newTestModel(t, tree)); err != nil {
+		t.Fatalf("WriteMarkdownReport() error = %v", err)
+	}
+	report := out.String()
+
+	tests := []struct {
+		parameter string
+		// The row of the parameter in the parameter changes table, empty if the parameter must not be listed
+		want string
+	}{
+		{"InstanceType", "| `InstanceType` | `t3.micro` | `t3.small` | yes |"},
+		{"Purpose", "| `Purpose` | `test` | `production` | **no** |"},
+		{"Environment", ""},
+		{"Password", "| `Password` | `****` | `****` | yes |"},
+		{"ApiKey", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.parameter, func(t *testing.T) {
+			row := "| `" + tt.parameter + "` |"
+			if tt.want == "" {
+				if strings.Contains(report, row) {
+					t.Errorf("report lists %s as a parameter change:\n%s", tt.parameter, report)
+				}
+				return
+			}
+			if !strings.Contains(report, tt.want) {
+				t.Errorf("report does not contain %q:\n%s", tt.want, report)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package util
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+type mermaidWriter struct {
+	*bufio.Writer
+
+	// Mermaid ids, indexed by our node ids
+	ids map[string]string
+	// Number of links written so far, links are styled by their index
+	links int
+
+	opts *GraphOpts
+}
+
+// Find or create a mermaid-compatible id for a node id
+func (w *mermaidWriter) id(nodeId string) string {
+	id, present := w.ids[nodeId]
+	if !present {
+		id = fmt.Sprintf("n%d", len(w.ids)+1)
+		w.ids[nodeId] = id
+	}
+	return id
+}
+
+func mermaidText(lines ...string) string {
+	escaped := make([]string, len(lines))
+	for i, line := range lines {
+		escaped[i] = strings.ReplaceAll(line, `"`, "#quot;")
+	}
+	return fmt.Sprintf(`"%s"`, strings.Join(escaped, "<br/>"))
+}
+
+func (w *mermaidWriter) writeClassDefs() {
+	classes := []struct {
+		name   string
+		stroke color
+		fill   color
+	}{
+		{"added", addedResourceColor, ""},
+		{"removed", removedResourceColor, removedResourceFillColor},
+		{"modified", modifiedResourceColor, ""},
+		{"imported", importedResourceColor, ""},
+		{"dynamic", dynamicResourceColor, ""},
+		{"replaced", "", replacedResourceFillColor},
+		{"maybeReplaced", "", maybeReplacedResourceFillColor},
+		{"usedParameter", usedParameterColor, ""},
+		{"unusedParameter", unusedParameterColor, ""},
+		{"output", outputColor, ""},
+		{"failed", failedStackColor, failedStackFillColor},
+	}
+	for _, class := range classes {
+		styles := []string{}
+		if class.stroke != "" {
+			styles = append(styles, fmt.Sprintf("stroke:%s", colorToHex(class.stroke)), "stroke-width:2px")
+		}
+		if class.fill != "" {
+			styles = append(styles, fmt.Sprintf("fill:%s", colorToHex(class.fill)))
+		}
+		fmt.Fprintf(w, "  classDef %s %s\n", class.name, strings.Join(styles, ","))
+	}
+	fmt.Fprintf(w, "  classDef directModification fill:none,stroke:none\n")
+	fmt.Fprintf(w, "  classDef elided stroke-dasharray:5 5\n")
+	// Defined last, so that the wider border wins over the other classes
+	fmt.Fprintf(w, "  classDef drifted stroke-width:%dpx\n", 2*driftedPenWidth)
+}
+
+// The classes for a resource change, matching the class definitions
+func mermaidResourceChangeClasses(change types.ResourceChange) []string {
+	classes := []string{}
+	switch change.Action {
+	case types.ChangeActionAdd:
+		classes = append(classes, "added")
+	case types.ChangeActionRemove:
+		classes = append(classes, "removed")
+	case types.ChangeActionModify:
+		classes = append(classes, "modified")
+	case types.ChangeActionImport:
+		classes = append(classes, "imported")
+	case types.ChangeActionDynamic:
+		classes = append(classes, "dynamic")
+	}
+	if change.Action != types.ChangeActionRemove {
+		switch change.Replacement {
+		case types.ReplacementTrue:
+			classes = append(classes, "replaced")
+		case types.ReplacementConditional:
+			classes = append(classes, "maybeReplaced")
+		}
+	}
+	return classes
+}
+
+func (w *mermaidWriter) writeClasses(indent string, id string, classes []string) {
+	for _, class := range classes {
+		fmt.Fprintf(w, "%sclass %s %s\n", indent, id, class)
+	}
+}
+
+func (w *mermaidWriter) writeStack(stack *Stack, indent string) {
+	for _, r := range stack.Resources {
+		if r.NestedStack != nil {
+			nested := r.NestedStack
+			id := w.id(r.Id())
+			fmt.Fprintf(w, "%ssubgraph %s[%s]\n", indent, id, mermaidText(resourceLabel(r)...))
+			w.writeStack(nested, indent+"  ")
+			fmt.Fprintf(w, "%send\n", indent)
+			if nested.Err != nil {
+				w.writeClasses(indent, id, []string{"failed"})
+			} else if rc := r.ResourceChange(); rc != nil {
+				w.writeClasses(indent, id, mermaidResourceChangeClasses(*rc))
+			}
+			if r.DriftedChange() {
+				w.writeClasses(indent, id, []string{"drifted"})
+			}
+			continue
+		}
+
+		id := w.id(r.Id())
+		if verdict, _ := r.StackPolicyVerdict(); verdict != "" {
+			// Hexagons are the closest to the octagons used in the Graphviz output
+			fmt.Fprintf(w, "%s%s{{%s}}\n", indent, id, mermaidText(resourceLabel(r)...))
+		} else {
+			fmt.Fprintf(w, "%s%s[%s]\n", indent, id, mermaidText(resourceLabel(r)...))
+		}
+		if r.Change != nil && r.Change.Type == types.ChangeTypeResource {
+			w.writeClasses(indent, id, mermaidResourceChangeClasses(*r.ResourceChange()))
+		}
+		if r.DriftedChange() {
+			w.writeClasses(indent, id, []string{"drifted"})
+		}
+		if len(r.Elided) > 0 {
+			w.writeClasses(indent, id, []string{"elided"})
+		}
+	}
+
+	used, unused := w.opts.splitParameters(stack)
+	for _, parameter := range used {
+		id := w.id(parameter.Id())
+		fmt.Fprintf(w, "%s%s([%s])\n", indent, id, mermaidText(parameterLabel(parameter)))
+		w.writeClasses(indent, id, []string{"usedParameter"})
+	}
+	for _, parameter := range unused {
+		id := w.id(parameter.Id())
+		fmt.Fprintf(w, "%s%s([%s])\n", indent, id, mermaidText(parameterLabel(parameter), "(causes no changes)"))
+		w.writeClasses(indent, id, []string{"unusedParameter"})
+	}
+
+	for _, output := range stack.ReferencedOutputs() {
+		id := w.id(makeNodeId(stack.Name, fmt.Sprintf("%s.%s", outputsNodeName, output)))
+		fmt.Fprintf(w, "%s%s[/%s/]\n", indent, id, mermaidText(output))
+		w.writeClasses(indent, id, []string{"output"})
+	}
+
+	for _, cause := range stack.Causes {
+		if cause.Source == types.ChangeSourceDirectModification {
+			id := w.id(makeNodeId(stack.Name, "Direct modification"))
+			fmt.Fprintf(w, "%s%s[%s]\n", indent, id, mermaidText("Direct modification"))
+			w.writeClasses(indent, id, []string{"directModification"})
+			break
+		}
+	}
+}
+
+func (w *mermaidWriter) writeEdges(stack *Stack) {
+	for _, cause := range stack.Causes {
+		var sourceId string
+		switch {
+		case cause.Parameter != nil:
+			sourceId = cause.Parameter.Id()
+		case cause.SourceOutput() != "":
+			sourceId = makeNodeId(cause.Resource.NestedStack.Name, fmt.Sprintf("%s.%s", outputsNodeName, cause.SourceOutput()))
+		case cause.Resource != nil:
+			sourceId = cause.Resource.Id()
+		default:
+			sourceId = makeNodeId(stack.Name, "Direct modification")
+		}
+
+		// Static evaluations are solid, dynamic ones dotted
+		arrow := "-->"
+		if cause.Evaluation == types.EvaluationTypeDynamic {
+			arrow = "-.->"
+		}
+		label := ""
+		if targetLabel := causeTargetLabel(cause); targetLabel != "" {
+			label = fmt.Sprintf("|%s|", mermaidText(targetLabel))
+		}
+		fmt.Fprintf(w, "  %s %s%s %s\n", w.id(sourceId), arrow, label, w.id(cause.Changed.Id()))
+		// Point out the changes that force a replacement
+		if color, _ := recreationEdgeStyle(cause); color != "" {
+			fmt.Fprintf(w, "  linkStyle %d stroke:%s,stroke-width:3px,color:%s\n", w.links, colorToHex(color), colorToHex(color))
+		}
+		w.links++
+	}
+
+	for _, nested := range stack.Nested {
+		w.writeEdges(nested)
+	}
+}
+
+// Write the changeset model as a Mermaid flowchart
+//
+// Nested stacks become subgraphs, parameters that cause changes become nodes, and the change actions
+// use the same colors as the Graphviz output.
+func WriteMermaidGraph(out io.Writer, model *ChangeSetModel, opts *GraphOpts) error {
+	w := &mermaidWriter{Writer: bufio.NewWriter(out), ids: map[string]string{}, opts: opts}
+
+	fmt.Fprintf(w, "flowchart LR\n")
+	w.writeClassDefs()
+	w.writeStack(model.Root, "  ")
+	w.writeEdges(model.Root)
+
+	return w.Flush()
+}
@@ -0,0 +1,1005 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// The interpretation of a (nested) changeset, independent of how it gets rendered
+type ChangeSetModel struct {
+	Root *Stack
+
+	// All stacks, indexed by StackName
+	Stacks map[string]*Stack
+}
+
+// A stack and the changes planned for it
+type Stack struct {
+	Name string
+	// Logical id of the stack resource in the parent stack, empty for the root
+	LogicalResourceId string
+	// The parent stack, nil for the root
+	Parent *Stack
+
+	// The changeset, nil if there is no changeset for a nested stack
+	ChangeSet *cloudformation.DescribeChangeSetOutput
+	// The error if the stack could not be processed
+	Err error
+	// The processed template of the changeset and the current template of the stack, nil if not known
+	Template        *Template
+	CurrentTemplate *Template
+	// The currently deployed stack, nil if not known
+	CurrentStack *types.Stack
+	// The stack policy of the deployed stack, nil if the stack has none or it is not known
+	StackPolicy *StackPolicy
+
+	// Parameters, in the order of the changeset followed by parameters only known from causes
+	Parameters []*Parameter
+	// Changed resources in the order of the changeset, followed by unchanged resources that cause changes
+	Resources []*Resource
+	// Nested stacks, in the order of the changes in the changeset
+	Nested []*Stack
+	// All cause edges leading to changes in this stack
+	Causes []*Cause
+	// Hooks that will be invoked for changes in this stack
+	Hooks []*Hook
+
+	parameters map[string]*Parameter
+	resources  map[string]*Resource
+}
+
+type Parameter struct {
+	Stack *Stack
+	Name  string
+	Value string
+
+	// The value in the currently deployed stack, only known when the current stack is known
+	PreviousValue    string
+	HasPreviousValue bool
+	// Whether the value is hidden by CloudFormation
+	NoEcho bool
+
+	// Changes caused by this parameter
+	Effects []*Cause
+}
+
+type Resource struct {
+	Stack             *Stack
+	LogicalResourceId string
+
+	// The change of this resource, nil if the resource is only referenced as the cause of other changes
+	Change *types.Change
+	// The stack if this resource is a nested stack
+	NestedStack *Stack
+
+	// Causes of the change of this resource
+	Causes []*Cause
+	// Changes caused by this resource
+	Effects []*Cause
+	// Hooks that will be invoked for the change of this resource
+	Hooks []*Hook
+	// The drift of the deployed resource, nil if the resource didn't drift or the drift wasn't checked
+	Drift *types.StackResourceDrift
+	// For nodes standing in for changes left out by a filter: the logical ids of the left out resources
+	Elided []string
+}
+
+// A CloudFormation Hook that will be invoked for a change
+type Hook struct {
+	TypeName        string
+	FailureMode     types.HookFailureMode
+	InvocationPoint types.HookInvocationPoint
+	TargetType      types.HookTargetType
+
+	// The resource the hook is invoked for, nil if the target is not a known resource
+	Resource *Resource
+	// The action of the target resource, and its type
+	ResourceAction types.ChangeAction
+	ResourceType   string
+}
+
+// An edge from the entity causing a change to the changed resource
+type Cause struct {
+	Source     types.ChangeSource
+	Evaluation types.EvaluationType
+
+	// The causing parameter, for ParameterReference causes
+	Parameter *Parameter
+	// The causing resource, for ResourceReference and ResourceAttribute causes
+	Resource *Resource
+	// The attribute of the causing resource for ResourceAttribute causes, "Outputs.Name" for outputs of nested stacks
+	SourceAttribute string
+
+	// The changed resource
+	Changed *Resource
+	// The changed attribute, and for properties the name of the property
+	TargetAttribute    types.ResourceAttribute
+	TargetName         string
+	RequiresRecreation types.RequiresRecreation
+
+	// The detail information this cause was derived from
+	Detail types.ResourceChangeDetail
+}
+
+func makeNodeId(stackName string, name string) string {
+	return fmt.Sprintf("%s.%s", stackName, name)
+}
+
+func (p *Parameter) Id() string {
+	return makeNodeId(p.Stack.Name, fmt.Sprintf("%s.%s", parametersNodeName, p.Name))
+}
+
+func (r *Resource) Id() string {
+	return makeNodeId(r.Stack.Name, r.LogicalResourceId)
+}
+
+// The resource change, nil for unchanged resources and for non-resource changes
+func (r *Resource) ResourceChange() *types.ResourceChange {
+	if r.Change == nil {
+		return nil
+	}
+	return r.Change.ResourceChange
+}
+
+// The type of the resource, if known
+func (r *Resource) ResourceType() string {
+	if rc := r.ResourceChange(); rc != nil {
+		return aws.ToString(rc.ResourceType)
+	}
+	return ""
+}
+
+// The number of hook invocations announced for the change of this resource
+func (r *Resource) HookInvocationCount() int {
+	if r.Change == nil {
+		return 0
+	}
+	return int(aws.ToInt32(r.Change.HookInvocationCount))
+}
+
+// What happens to a resource that gets removed, or to the old resource when it gets replaced
+type ResourceFate string
+
+const (
+	ResourceFateDeleted     ResourceFate = "will be deleted"
+	ResourceFateRetained    ResourceFate = "will be retained/orphaned"
+	ResourceFateSnapshotted ResourceFate = "will be snapshotted"
+)
+
+// Whether the resource gets replaced, or might get replaced
+func (r *Resource) Replaced() bool {
+	rc := r.ResourceChange()
+	return rc != nil && rc.Action == types.ChangeActionModify && (rc.Replacement == types.ReplacementTrue || rc.Replacement == types.ReplacementConditional)
+}
+
+// The fate of a removed or replaced resource, empty for other changes or when the template isn't known
+//
+// Removals follow the `DeletionPolicy` in the current template of the stack, replacements the `UpdateReplacePolicy`
+// in the new template.
+func (r *Resource) Fate() ResourceFate {
+	rc := r.ResourceChange()
+	if rc == nil {
+		return ""
+	}
+	var policy string
+	switch {
+	case rc.Action == types.ChangeActionRemove:
+		resource := r.Stack.CurrentTemplate.FindResource(r.LogicalResourceId)
+		if resource == nil {
+			return ""
+		}
+		policy = resource.EffectiveDeletionPolicy()
+	case r.Replaced():
+		resource := r.Stack.Template.FindResource(r.LogicalResourceId)
+		if resource == nil {
+			resource = r.Stack.CurrentTemplate.FindResource(r.LogicalResourceId)
+		}
+		if resource == nil {
+			return ""
+		}
+		policy = resource.EffectiveUpdateReplacePolicy()
+	default:
+		return ""
+	}
+
+	switch policy {
+	case "Delete":
+		return ResourceFateDeleted
+	case "Retain", "RetainExceptOnCreate":
+		return ResourceFateRetained
+	case "Snapshot":
+		return ResourceFateSnapshotted
+	}
+	return ""
+}
+
+// A short sentence describing the fate of a removed or replaced resource, empty if the fate is not known
+func (r *Resource) FateDescription() string {
+	fate := r.Fate()
+	switch {
+	case fate == "":
+		return ""
+	case r.Replaced() && r.ResourceChange().Replacement == types.ReplacementConditional:
+		return fmt.Sprintf("if replaced, the old resource %s", fate)
+	case r.Replaced():
+		return fmt.Sprintf("the old resource %s", fate)
+	}
+	return fmt.Sprintf("the resource %s", fate)
+}
+
+// Whether the resource drifted and gets modified or replaced by the changeset
+//
+// The changeset is computed against the template, not against the actual resource, so executing it can
+// have surprising results for such resources.
+func (r *Resource) DriftedChange() bool {
+	rc := r.ResourceChange()
+	return r.Drift != nil && rc != nil && rc.Action == types.ChangeActionModify
+}
+
+// Descriptions of the differences between the expected and the actual properties of a drifted resource
+func (r *Resource) DriftDescriptions() []string {
+	result := []string{}
+	if r.Drift == nil {
+		return result
+	}
+	if r.Drift.StackResourceDriftStatus == types.StackResourceDriftStatusDeleted {
+		return append(result, "resource was deleted")
+	}
+	for _, difference := range r.Drift.PropertyDifferences {
+		result = append(result, fmt.Sprintf("%s: %s -> %s (%s)", aws.ToString(difference.PropertyPath), aws.ToString(difference.ExpectedValue), aws.ToString(difference.ActualValue), difference.DifferenceType))
+	}
+	return result
+}
+
+// A short description of a hook, "TypeName (TargetType, FailureMode)"
+func (hook *Hook) String() string {
+	details := []string{}
+	for _, detail := range []string{string(hook.TargetType), string(hook.FailureMode)} {
+		if detail != "" {
+			details = append(details, detail)
+		}
+	}
+	if len(details) == 0 {
+		return hook.TypeName
+	}
+	return fmt.Sprintf("%s (%s)", hook.TypeName, strings.Join(details, ", "))
+}
+
+// Descriptions of the hooks invoked for the change of the resource
+//
+// Without details about the hooks only the number of invocations is described.
+func (r *Resource) HookDescriptions() []string {
+	result := []string{}
+	for _, hook := range r.Hooks {
+		result = append(result, hook.String())
+	}
+	if len(result) == 0 && r.HookInvocationCount() > 0 {
+		result = append(result, fmt.Sprintf("%d invocation(s)", r.HookInvocationCount()))
+	}
+	return result
+}
+
+// The values of a changed property before and after the change, taken from the templates
+type PropertyChange struct {
+	Name string
+
+	Before    interface{}
+	BeforeSet bool
+	After     interface{}
+	AfterSet  bool
+}
+
+func formatPropertyValue(value interface{}, set bool) string {
+	if !set {
+		return "(not set)"
+	}
+	return FormatTemplateValue(value)
+}
+
+// Whether the value is the same before and after the change
+//
+// For values from the templates the property still changes when the value references other resources or
+// parameters that change.
+func (c *PropertyChange) SameValue() bool {
+	return c.BeforeSet && c.AfterSet && FormatTemplateValue(c.Before) == FormatTemplateValue(c.After)
+}
+
+// The change as "Name: Before -> After"
+func (c *PropertyChange) String() string {
+	if c.SameValue() {
+		return fmt.Sprintf("%s: %s (value unchanged)", c.Name, FormatTemplateValue(c.After))
+	}
+	return fmt.Sprintf("%s: %s -> %s", c.Name, formatPropertyValue(c.Before, c.BeforeSet), formatPropertyValue(c.After, c.AfterSet))
+}
+
+// The name of the property changed by the target, empty if the target is not a property
+func targetPropertyName(target *types.ResourceTargetDefinition) string {
+	switch target.Attribute {
+	case types.ResourceAttributeProperties:
+		return aws.ToString(target.Name)
+	case types.ResourceAttributeTags:
+		return string(types.ResourceAttributeTags)
+	}
+	return ""
+}
+
+// The changed property values reported in the targets of the change details, when the changeset was described with
+// `IncludePropertyValues`
+//
+// The properties are named by their path without the "/Properties/" prefix, so that changes of nested values
+// ("Tags/0/Value") can be told apart.
+func targetValueChanges(rc *types.ResourceChange) []*PropertyChange {
+	result := []*PropertyChange{}
+	seen := []string{}
+	for _, detail := range rc.Details {
+		target := detail.Target
+		if target == nil || (target.BeforeValue == nil && target.AfterValue == nil) {
+			continue
+		}
+		name := strings.TrimPrefix(strings.TrimPrefix(aws.ToString(target.Path), "/Properties"), "/")
+		if name == "" {
+			name = targetPropertyName(target)
+		}
+		if contains(seen, name) {
+			// Static and dynamic details for the same target
+			continue
+		}
+		seen = append(seen, name)
+
+		c := &PropertyChange{Name: name}
+		if target.BeforeValue != nil && target.AttributeChangeType != types.AttributeChangeTypeAdd {
+			c.Before, c.BeforeSet = aws.ToString(target.BeforeValue), true
+		}
+		if target.AfterValue != nil && target.AttributeChangeType != types.AttributeChangeTypeRemove {
+			c.After, c.AfterSet = aws.ToString(target.AfterValue), true
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+// The properties from a BeforeContext or AfterContext of a resource change, nil if there is no usable context
+func contextProperties(context *string) map[string]interface{} {
+	if context == nil {
+		return nil
+	}
+	var value map[string]interface{}
+	if err := json.Unmarshal([]byte(aws.ToString(context)), &value); err != nil {
+		log.Debugf("ignoring resource change context, %v", err)
+		return nil
+	}
+	if properties, ok := value["Properties"].(map[string]interface{}); ok {
+		return properties
+	}
+	return value
+}
+
+// The before and after values of the changed properties, in the order of the change details
+//
+// The values come from the changeset when it was described with `IncludePropertyValues`: preferably the values of
+// the targets, otherwise the contexts of the resource change. Without those the templates of the stack are used,
+// showing the template expressions rather than the resolved values. Only properties that are set before or after the
+// change are included.
+func (r *Resource) PropertyChanges() []*PropertyChange {
+	rc := r.ResourceChange()
+	if rc == nil {
+		return []*PropertyChange{}
+	}
+	if result := targetValueChanges(rc); len(result) > 0 {
+		return result
+	}
+
+	var before, after func(name string) (interface{}, bool)
+	beforeContext, afterContext := contextProperties(rc.BeforeContext), contextProperties(rc.AfterContext)
+	switch {
+	case beforeContext != nil || afterContext != nil:
+		before = func(name string) (interface{}, bool) {
+			value, present := beforeContext[name]
+			return value, present
+		}
+		after = func(name string) (interface{}, bool) {
+			value, present := afterContext[name]
+			return value, present
+		}
+	case r.Stack.Template != nil || r.Stack.CurrentTemplate != nil:
+		before = func(name string) (interface{}, bool) {
+			return r.Stack.CurrentTemplate.ResourceProperty(r.LogicalResourceId, name)
+		}
+		after = func(name string) (interface{}, bool) {
+			return r.Stack.Template.ResourceProperty(r.LogicalResourceId, name)
+		}
+	default:
+		return []*PropertyChange{}
+	}
+
+	names := []string{}
+	for _, detail := range rc.Details {
+		if detail.Target == nil {
+			continue
+		}
+		if name := targetPropertyName(detail.Target); name != "" && !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	result := []*PropertyChange{}
+	for _, name := range names {
+		c := &PropertyChange{Name: name}
+		c.Before, c.BeforeSet = before(name)
+		c.After, c.AfterSet = after(name)
+		if c.BeforeSet || c.AfterSet {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// The changed target as "Attribute.Name", or just "Attribute" for targets without a name
+func (c *Cause) TargetPath() string {
+	if c.TargetName == "" {
+		return string(c.TargetAttribute)
+	}
+	return fmt.Sprintf("%s.%s", c.TargetAttribute, c.TargetName)
+}
+
+// The id of the causing entity, or the change source for direct modifications
+func (c *Cause) SourceId() string {
+	switch {
+	case c.Parameter != nil:
+		return c.Parameter.Id()
+	case c.Resource != nil && c.SourceAttribute != "":
+		return fmt.Sprintf("%s.%s", c.Resource.Id(), c.SourceAttribute)
+	case c.Resource != nil:
+		return c.Resource.Id()
+	}
+	return string(c.Source)
+}
+
+// The name of the nested stack output causing the change, empty if the cause is not an output of a nested stack
+func (c *Cause) SourceOutput() string {
+	if c.Resource == nil || c.Resource.NestedStack == nil {
+		return ""
+	}
+	if !strings.HasPrefix(c.SourceAttribute, outputsNodeName+".") {
+		return ""
+	}
+	return strings.TrimPrefix(c.SourceAttribute, outputsNodeName+".")
+}
+
+// A stable id for this cause, "ChangedId:TargetPath<-SourceId"
+func (c *Cause) Id() string {
+	return fmt.Sprintf("%s:%s<-%s", c.Changed.Id(), c.TargetPath(), c.SourceId())
+}
+
+// Visit this stack and all nested stacks depth-first, parents before their nested stacks
+func (s *Stack) Walk(fn func(*Stack) error) error {
+	if err := fn(s); err != nil {
+		return err
+	}
+	for _, nested := range s.Nested {
+		if err := nested.Walk(fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The changed resources of this stack, in the order of the changeset
+func (s *Stack) Changes() []*Resource {
+	result := []*Resource{}
+	for _, r := range s.Resources {
+		if r.Change != nil {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// CloudFormation shows this instead of the values of NoEcho parameters
+const maskedParameterValue = "****"
+
+// Whether the value of the parameter differs from the value in the currently deployed stack
+//
+// This is only known when the current stack is known, and never for NoEcho parameters.
+func (p *Parameter) Changed() bool {
+	if p.Stack.CurrentStack == nil || p.NoEcho {
+		return false
+	}
+	return !p.HasPreviousValue || p.PreviousValue != p.Value
+}
+
+// Parameters whose value differs from the currently deployed stack
+func (s *Stack) ChangedParameters() []*Parameter {
+	result := []*Parameter{}
+	for _, p := range s.Parameters {
+		if p.Changed() {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// Parameters that cause at least one change
+func (s *Stack) UsedParameters() []*Parameter {
+	result := []*Parameter{}
+	for _, p := range s.Parameters {
+		if len(p.Effects) > 0 {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// Outputs of this nested stack that cause changes in the parent stack, in the order of the causes
+func (s *Stack) ReferencedOutputs() []string {
+	result := []string{}
+	if s.Parent == nil {
+		return result
+	}
+	stackResource := s.Parent.FindResource(s.LogicalResourceId)
+	if stackResource == nil {
+		return result
+	}
+	for _, cause := range stackResource.Effects {
+		if output := cause.SourceOutput(); output != "" && !contains(result, output) {
+			result = append(result, output)
+		}
+	}
+	return result
+}
+
+func (s *Stack) FindResource(logicalResourceId string) *Resource {
+	return s.resources[logicalResourceId]
+}
+
+func (s *Stack) FindParameter(name string) *Parameter {
+	return s.parameters[name]
+}
+
+func (s *Stack) findOrAddParameter(name string) *Parameter {
+	p, present := s.parameters[name]
+	if !present {
+		p = &Parameter{Stack: s, Name: name}
+		s.parameters[name] = p
+		s.Parameters = append(s.Parameters, p)
+	}
+	return p
+}
+
+func (s *Stack) findOrAddResource(logicalResourceId string) *Resource {
+	r, present := s.resources[logicalResourceId]
+	if !present {
+		r = &Resource{Stack: s, LogicalResourceId: logicalResourceId}
+		s.resources[logicalResourceId] = r
+		s.Resources = append(s.Resources, r)
+	}
+	return r
+}
+
+func makeTargetHash(target *types.ResourceTargetDefinition) string {
+	return fmt.Sprintf("%s.%s", target.Attribute, aws.ToString(target.Name))
+}
+
+func (s *Stack) addChangeCauses(changed *Resource) {
+	change := changed.ResourceChange()
+
+	// Walk through all targets, and try to figure out what they refer to.
+	// See https://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/using-cfn-updating-stacks-changesets-samples.html for
+	// some good examples and hints on how to read the changes
+	for _, detail := range change.Details {
+		causingEntity := aws.ToString(detail.CausingEntity)
+
+		if causingEntity == "" && detail.Evaluation == types.EvaluationTypeDynamic {
+			// Changes to parameters produce both a "dynamic" and a "static" change for the same target. The "static"
+			// change has better information, and we want to skip the dynamic one in that case.
+			targetHash := makeTargetHash(detail.Target)
+			foundMatchingStaticDetail := false
+			for _, innerDetail := range change.Details {
+				if innerDetail.Evaluation == types.EvaluationTypeStatic {
+					innerTargetHash := makeTargetHash(innerDetail.Target)
+					if innerTargetHash == targetHash {
+						foundMatchingStaticDetail = true
+						break
+					}
+				}
+			}
+			if foundMatchingStaticDetail {
+				continue
+			}
+		}
+
+		cause := &Cause{
+			Source:     detail.ChangeSource,
+			Evaluation: detail.Evaluation,
+			Changed:    changed,
+			Detail:     detail,
+		}
+		if detail.Target != nil {
+			cause.TargetAttribute = detail.Target.Attribute
+			cause.TargetName = aws.ToString(detail.Target.Name)
+			cause.RequiresRecreation = detail.Target.RequiresRecreation
+		}
+
+		switch detail.ChangeSource {
+		case types.ChangeSourceDirectModification:
+			// Nothing to resolve
+		case types.ChangeSourceParameterReference:
+			cause.Parameter = s.findOrAddParameter(causingEntity)
+			cause.Parameter.Effects = append(cause.Parameter.Effects, cause)
+		case types.ChangeSourceResourceReference:
+			cause.Resource = s.findOrAddResource(causingEntity)
+			cause.Resource.Effects = append(cause.Resource.Effects, cause)
+		case types.ChangeSourceResourceAttribute:
+			// CausingEntity is "LogicalResourceId.Attribute", for a nested stack "Attribute" could also be "Outputs.NameOfOutput"
+			logicalResourceId, attribute, _ := strings.Cut(causingEntity, ".")
+			cause.Resource = s.findOrAddResource(logicalResourceId)
+			cause.Resource.Effects = append(cause.Resource.Effects, cause)
+			cause.SourceAttribute = attribute
+		default:
+			log.Debugf("ignoring change detail with source %q for %s", detail.ChangeSource, changed.Id())
+			continue
+		}
+
+		changed.Causes = append(changed.Causes, cause)
+		s.Causes = append(s.Causes, cause)
+	}
+
+	if len(changed.Causes) == 0 {
+		log.Debugf("cannot find any understood change cause, %v", change)
+	}
+}
+
+func (s *Stack) addHooks(hooks []types.ChangeSetHook) {
+	for _, hook := range hooks {
+		h := &Hook{
+			TypeName:        aws.ToString(hook.TypeName),
+			FailureMode:     hook.FailureMode,
+			InvocationPoint: hook.InvocationPoint,
+		}
+		if details := hook.TargetDetails; details != nil {
+			h.TargetType = details.TargetType
+			if resourceDetails := details.ResourceTargetDetails; resourceDetails != nil {
+				h.ResourceAction = resourceDetails.ResourceAction
+				h.ResourceType = aws.ToString(resourceDetails.ResourceType)
+				h.Resource = s.FindResource(aws.ToString(resourceDetails.LogicalResourceId))
+			}
+		}
+		if h.Resource != nil {
+			h.Resource.Hooks = append(h.Resource.Hooks, h)
+		} else {
+			log.Debugf("cannot find target of hook %s in %s", h.TypeName, s.Name)
+		}
+		s.Hooks = append(s.Hooks, h)
+	}
+}
+
+func (s *Stack) addDrifts(drifts []types.StackResourceDrift) {
+	for i := range drifts {
+		drift := &drifts[i]
+		if drift.StackResourceDriftStatus != types.StackResourceDriftStatusModified && drift.StackResourceDriftStatus != types.StackResourceDriftStatusDeleted {
+			continue
+		}
+		// Drift only matters for resources that change
+		if r := s.FindResource(aws.ToString(drift.LogicalResourceId)); r != nil && r.Change != nil {
+			r.Drift = drift
+		}
+	}
+}
+
+func (m *ChangeSetModel) addStack(parent *Stack, tree *ChangeSetTree) (*Stack, error) {
+	if _, present := m.Stacks[tree.StackName]; present {
+		return nil, fmt.Errorf("stack %q exists?", tree.StackName)
+	}
+
+	stack := &Stack{
+		Name:              tree.StackName,
+		LogicalResourceId: tree.LogicalResourceId,
+		Parent:            parent,
+		ChangeSet:         tree.ChangeSet,
+		Err:               tree.Err,
+		parameters:        map[string]*Parameter{},
+		resources:         map[string]*Resource{},
+	}
+	m.Stacks[stack.Name] = stack
+	if parent != nil {
+		parent.Nested = append(parent.Nested, stack)
+	}
+
+	if stack.ChangeSet == nil {
+		return stack, nil
+	}
+
+	// Templates are additional information, without them only the property values are missing
+	if tree.TemplateBody != "" {
+		template, err := ParseTemplate(tree.TemplateBody)
+		if err != nil {
+			log.Warnf("ignoring template of changeset for stack %s, %v", stack.Name, err)
+		}
+		stack.Template = template
+	}
+	if tree.CurrentTemplateBody != "" {
+		template, err := ParseTemplate(tree.CurrentTemplateBody)
+		if err != nil {
+			log.Warnf("ignoring current template of stack %s, %v", stack.Name, err)
+		}
+		stack.CurrentTemplate = template
+	}
+
+	if tree.StackPolicyBody != "" {
+		policy, err := ParseStackPolicy(tree.StackPolicyBody)
+		if err != nil {
+			log.Warnf("ignoring stack policy of stack %s, %v", stack.Name, err)
+		}
+		stack.StackPolicy = policy
+	}
+
+	for _, parameter := range stack.ChangeSet.Parameters {
+		p := stack.findOrAddParameter(aws.ToString(parameter.ParameterKey))
+		p.Value = aws.ToString(parameter.ParameterValue)
+	}
+	if tree.CurrentStack != nil {
+		stack.CurrentStack = tree.CurrentStack
+		for _, parameter := range tree.CurrentStack.Parameters {
+			// Parameters that are no longer in the changeset are gone, and cannot cause changes
+			if p := stack.FindParameter(aws.ToString(parameter.ParameterKey)); p != nil {
+				p.PreviousValue = aws.ToString(parameter.ParameterValue)
+				p.HasPreviousValue = true
+			}
+		}
+	}
+	for _, p := range stack.Parameters {
+		p.NoEcho = p.Value == maskedParameterValue || p.PreviousValue == maskedParameterValue || stack.Template.IsNoEchoParameter(p.Name) || stack.CurrentTemplate.IsNoEchoParameter(p.Name)
+	}
+
+	nestedTrees := map[string]*ChangeSetTree{}
+	for _, nested := range tree.Nested {
+		nestedTrees[nested.LogicalResourceId] = nested
+	}
+
+	// Phase 1: Collect all changed resources, and build the nested stacks
+	for i := range stack.ChangeSet.Changes {
+		change := &stack.ChangeSet.Changes[i]
+		if change.ResourceChange == nil {
+			log.Warnf("ignoring %q change without resource change in %s", change.Type, stack.Name)
+			continue
+		}
+
+		logicalResourceId := aws.ToString(change.ResourceChange.LogicalResourceId)
+		r := stack.findOrAddResource(logicalResourceId)
+		r.Change = change
+
+		if nestedTree, present := nestedTrees[logicalResourceId]; present && isNestedStackChange(*change) {
+			log.Infof("processing %q nested stack %v.%v", change.ResourceChange.Action, stack.Name, logicalResourceId)
+
+			nestedStack, err := m.addStack(stack, nestedTree)
+			if err != nil {
+				return nil, err
+			}
+			r.NestedStack = nestedStack
+		}
+	}
+
+	// Phase 2: Find the causes of the changes
+	for _, r := range stack.Changes() {
+		if r.Change.Type != types.ChangeTypeResource {
+			continue
+		}
+		stack.addChangeCauses(r)
+	}
+
+	// Phase 3: Attach the hooks to the changed resources
+	stack.addHooks(tree.Hooks)
+
+	// Phase 4: Attach the drift of the deployed resources to the changed resources
+	stack.addDrifts(tree.Drifts)
+
+	return stack, nil
+}
+
+// Stacks that could not be processed, in depth-first order
+func (m *ChangeSetModel) FailedStacks() []*Stack {
+	result := []*Stack{}
+	m.Root.Walk(func(s *Stack) error {
+		if s.Err != nil {
+			result = append(result, s)
+		}
+		return nil
+	})
+	return result
+}
+
+// The id of the stack, "ParentStackName.LogicalResourceId" for nested stacks and the stack name for the root
+func (s *Stack) Id() string {
+	if s.Parent == nil {
+		return s.Name
+	}
+	return makeNodeId(s.Parent.Name, s.LogicalResourceId)
+}
+
+func makeLogicalPath(stackPath string, name string) string {
+	if stackPath == "" {
+		return name
+	}
+	return makeNodeId(stackPath, name)
+}
+
+// The logical ids of the nested stack resources leading to this stack, separated by dots and empty for the root
+//
+// Unlike the stack name this does not depend on the names generated for nested stacks.
+func (s *Stack) LogicalPath() string {
+	if s.Parent == nil {
+		return ""
+	}
+	return makeLogicalPath(s.Parent.LogicalPath(), s.LogicalResourceId)
+}
+
+// The logical path of the resource, "NestedStack.LogicalResourceId" for resources in nested stacks
+func (r *Resource) LogicalPath() string {
+	return makeLogicalPath(r.Stack.LogicalPath(), r.LogicalResourceId)
+}
+
+// The logical path of the parameter, "NestedStack.Parameters.ParameterKey" for parameters of nested stacks
+func (p *Parameter) LogicalPath() string {
+	return makeLogicalPath(p.Stack.LogicalPath(), fmt.Sprintf("%s.%s", parametersNodeName, p.Name))
+}
+
+// Interpret the changeset tree
+func NewChangeSetModel(tree *ChangeSetTree) (*ChangeSetModel, error) {
+	model := &ChangeSetModel{Stacks: map[string]*Stack{}}
+	root, err := model.addStack(nil, tree)
+	if err != nil {
+		return nil, err
+	}
+	model.Root = root
+	return model, nil
+}
+
+// The parts of a model to keep in a subset
+type modelSubset struct {
+	keepResource map[*Resource]bool
+	keepCause    map[*Cause]bool
+	// Parameters to leave out, all other parameters are kept
+	dropParameter map[*Parameter]bool
+	// Nodes standing in for left out changes, indexed by their stack
+	elided map[*Stack][]*elidedChanges
+}
+
+// Copy the cause into the subset stack, connecting it to the given changed resource and the kept source
+func (result *Stack) addSubsetCause(cause *Cause, changed *Resource, source *Resource) {
+	kept := *cause
+	kept.Changed = changed
+	kept.Changed.Causes = append(kept.Changed.Causes, &kept)
+	if cause.Parameter != nil {
+		kept.Parameter = result.FindParameter(cause.Parameter.Name)
+		kept.Parameter.Effects = append(kept.Parameter.Effects, &kept)
+	}
+	if source != nil {
+		kept.Resource = source
+		kept.Resource.Effects = append(kept.Resource.Effects, &kept)
+	}
+	result.Causes = append(result.Causes, &kept)
+}
+
+// Keep the resource, and the stack resources of all nested stacks containing it
+func (subset *modelSubset) keep(r *Resource) {
+	if subset.keepResource[r] {
+		return
+	}
+	subset.keepResource[r] = true
+	if parent := r.Stack.Parent; parent != nil {
+		if stackResource := parent.FindResource(r.Stack.LogicalResourceId); stackResource != nil {
+			subset.keep(stackResource)
+		}
+	}
+}
+
+func (m *ChangeSetModel) subsetStack(parent *Stack, stack *Stack, subset *modelSubset) *Stack {
+	result := &Stack{
+		Name:              stack.Name,
+		LogicalResourceId: stack.LogicalResourceId,
+		Parent:            parent,
+		ChangeSet:         stack.ChangeSet,
+		Err:               stack.Err,
+		Template:          stack.Template,
+		CurrentTemplate:   stack.CurrentTemplate,
+		CurrentStack:      stack.CurrentStack,
+		StackPolicy:       stack.StackPolicy,
+		parameters:        map[string]*Parameter{},
+		resources:         map[string]*Resource{},
+	}
+	m.Stacks[result.Name] = result
+	if parent != nil {
+		parent.Nested = append(parent.Nested, result)
+	}
+
+	for _, p := range stack.Parameters {
+		if subset.dropParameter[p] {
+			continue
+		}
+		kept := result.findOrAddParameter(p.Name)
+		kept.Value = p.Value
+		kept.PreviousValue = p.PreviousValue
+		kept.HasPreviousValue = p.HasPreviousValue
+		kept.NoEcho = p.NoEcho
+	}
+	for _, r := range stack.Resources {
+		if !subset.keepResource[r] {
+			continue
+		}
+		kept := result.findOrAddResource(r.LogicalResourceId)
+		kept.Change = r.Change
+		kept.Drift = r.Drift
+		if r.NestedStack != nil {
+			kept.NestedStack = m.subsetStack(result, r.NestedStack, subset)
+		}
+	}
+	for _, elided := range subset.elided[stack] {
+		result.findOrAddResource(elided.logicalResourceId).Elided = elided.resources
+	}
+	for _, cause := range stack.Causes {
+		if !subset.keepCause[cause] {
+			continue
+		}
+		var source *Resource
+		if cause.Resource != nil {
+			source = result.FindResource(cause.Resource.LogicalResourceId)
+		}
+		result.addSubsetCause(cause, result.FindResource(cause.Changed.LogicalResourceId), source)
+	}
+	for _, elided := range subset.elided[stack] {
+		node := result.FindResource(elided.logicalResourceId)
+		for _, cause := range elided.entries {
+			var source *Resource
+			if cause.Resource != nil {
+				source = result.FindResource(cause.Resource.LogicalResourceId)
+			}
+			result.addSubsetCause(cause, node, source)
+		}
+		for _, cause := range elided.exits {
+			result.addSubsetCause(cause, result.FindResource(cause.Changed.LogicalResourceId), node)
+			// The elided node has no outputs
+			result.Causes[len(result.Causes)-1].SourceAttribute = ""
+		}
+	}
+	for _, hook := range stack.Hooks {
+		if hook.Resource == nil || !subset.keepResource[hook.Resource] {
+			continue
+		}
+		kept := *hook
+		kept.Resource = result.FindResource(hook.Resource.LogicalResourceId)
+		kept.Resource.Hooks = append(kept.Resource.Hooks, &kept)
+		result.Hooks = append(result.Hooks, &kept)
+	}
+	return result
+}
+
+// Build the model with the kept parts of the original model
+func (subset *modelSubset) apply(root *Stack) *ChangeSetModel {
+	result := &ChangeSetModel{Stacks: map[string]*Stack{}}
+	result.Root = result.subsetStack(nil, root, subset)
+	return result
+}
+
+// A copy of the model restricted to the given resources and cause edges
+//
+// The resources at both ends of the cause edges are kept as well, and so are the stack resources of all nested
+// stacks containing kept resources.
+func (m *ChangeSetModel) Subset(resources []*Resource, causes []*Cause) *ChangeSetModel {
+	subset := &modelSubset{keepResource: map[*Resource]bool{}, keepCause: map[*Cause]bool{}}
+	for _, r := range resources {
+		subset.keep(r)
+	}
+	for _, cause := range causes {
+		subset.keepCause[cause] = true
+		subset.keep(cause.Changed)
+		if cause.Resource != nil {
+			subset.keep(cause.Resource)
+		}
+	}
+	return subset.apply(m.Root)
+}
@@ -0,0 +1,228 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"gopkg.in/yaml.v3"
+)
+
+// What happens when a policy rule matches a change
+type PolicyEffect string
+
+const (
+	PolicyEffectDeny PolicyEffect = "deny"
+	PolicyEffectWarn PolicyEffect = "warn"
+)
+
+// Pseudo-action matching modifications that replace or might replace the resource
+const policyActionReplace types.ChangeAction = "Replace"
+
+// A rule of a policy
+//
+// All given criteria must match for the rule to match a change, and within each criterion one of the values must
+// match. Stack names and resource types are glob patterns.
+type PolicyRule struct {
+	Name    string       `yaml:"name"`
+	Effect  PolicyEffect `yaml:"effect"`
+	Message string       `yaml:"message"`
+
+	Stacks        []string `yaml:"stacks"`
+	ResourceTypes []string `yaml:"resourceTypes"`
+	// CloudFormation change actions, or "Replace" for modifications that (might) replace the resource
+	Actions     []string `yaml:"actions"`
+	Replacement []string `yaml:"replacement"`
+	Scope       []string `yaml:"scope"`
+	// Names of parameters that cause the change
+	Parameters []string `yaml:"parameters"`
+}
+
+// A set of rules to check changesets against
+type Policy struct {
+	Rules []*PolicyRule `yaml:"rules"`
+}
+
+// A change matched by a policy rule
+type PolicyViolation struct {
+	Rule     *PolicyRule
+	Resource *Resource
+}
+
+func validatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q, %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Check that all values are one of the known values, ignoring case
+func validateValues[E ~string](name string, values []string, known []E) error {
+	for _, value := range values {
+		found := false
+		for _, k := range known {
+			if strings.EqualFold(value, string(k)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown %s %q", name, value)
+		}
+	}
+	return nil
+}
+
+func (rule *PolicyRule) validate() error {
+	switch rule.Effect {
+	case "":
+		rule.Effect = PolicyEffectDeny
+	case PolicyEffectDeny, PolicyEffectWarn:
+	default:
+		return fmt.Errorf("unknown effect %q, must be %q or %q", rule.Effect, PolicyEffectDeny, PolicyEffectWarn)
+	}
+	if len(rule.Stacks) == 0 && len(rule.ResourceTypes) == 0 && len(rule.Actions) == 0 && len(rule.Replacement) == 0 && len(rule.Scope) == 0 && len(rule.Parameters) == 0 {
+		return fmt.Errorf("must match on at least one of stacks, resourceTypes, actions, replacement, scope or parameters")
+	}
+	actions := append(types.ChangeAction("").Values(), policyActionReplace)
+	if err := validateValues("action", rule.Actions, actions); err != nil {
+		return err
+	}
+	if err := validateValues("replacement", rule.Replacement, types.Replacement("").Values()); err != nil {
+		return err
+	}
+	if err := validateValues("scope", rule.Scope, types.ResourceAttribute("").Values()); err != nil {
+		return err
+	}
+	if err := validatePatterns(rule.Stacks); err != nil {
+		return err
+	}
+	return validatePatterns(rule.ResourceTypes)
+}
+
+// Parse a policy from YAML
+func ParsePolicy(data []byte) (*Policy, error) {
+	policy := &Policy{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(policy); err != nil {
+		return nil, fmt.Errorf("cannot parse policy, %v", err)
+	}
+	for i, rule := range policy.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("invalid policy rule %q, %v", rule.Name, err)
+		}
+	}
+	return policy, nil
+}
+
+// Load a policy from a YAML file
+func LoadPolicy(fileName string) (*Policy, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read policy, %v", err)
+	}
+	return ParsePolicy(data)
+}
+
+func matchesAnyPattern(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		// Patterns are validated when loading the policy
+		if matched, _ := path.Match(pattern, s); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAnyValue(values []string, s string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *PolicyRule) matchesAction(change *types.ResourceChange) bool {
+	if matchesAnyValue(rule.Actions, string(change.Action)) {
+		return true
+	}
+	// Conditional replacements count as replacements, so that a gate rather fails than lets a replacement through
+	if change.Action != types.ChangeActionModify || !matchesAnyValue(rule.Actions, string(policyActionReplace)) {
+		return false
+	}
+	return change.Replacement == types.ReplacementTrue || change.Replacement == types.ReplacementConditional
+}
+
+func (rule *PolicyRule) matchesParameters(r *Resource) bool {
+	for _, cause := range r.Causes {
+		if cause.Parameter != nil && matchesAnyValue(rule.Parameters, cause.Parameter.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (rule *PolicyRule) matchesScope(change *types.ResourceChange) bool {
+	for _, scope := range change.Scope {
+		if matchesAnyValue(rule.Scope, string(scope)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the rule matches the change of the resource
+func (rule *PolicyRule) Matches(r *Resource) bool {
+	change := r.ResourceChange()
+	if change == nil {
+		return false
+	}
+	if len(rule.Stacks) > 0 && !matchesAnyPattern(rule.Stacks, r.Stack.Name) {
+		return false
+	}
+	if len(rule.ResourceTypes) > 0 && !matchesAnyPattern(rule.ResourceTypes, aws.ToString(change.ResourceType)) {
+		return false
+	}
+	if len(rule.Actions) > 0 && !rule.matchesAction(change) {
+		return false
+	}
+	if len(rule.Replacement) > 0 && !matchesAnyValue(rule.Replacement, string(change.Replacement)) {
+		return false
+	}
+	if len(rule.Scope) > 0 && !rule.matchesScope(change) {
+		return false
+	}
+	if len(rule.Parameters) > 0 && !rule.matchesParameters(r) {
+		return false
+	}
+	return true
+}
+
+// Check all changes in the root stack and the nested stacks against the rules of the policy
+//
+// Violations are ordered by stack and change, and for each change by the order of the rules.
+func (p *Policy) Evaluate(model *ChangeSetModel) []*PolicyViolation {
+	violations := []*PolicyViolation{}
+	model.Root.Walk(func(stack *Stack) error {
+		for _, r := range stack.Changes() {
+			for _, rule := range p.Rules {
+				if rule.Matches(r) {
+					violations = append(violations, &PolicyViolation{Rule: rule, Resource: r})
+				}
+			}
+		}
+		return nil
+	})
+	return violations
+}
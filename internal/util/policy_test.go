@@ -0,0 +1,133 @@
+package util
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func TestParsePolicyErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		wantErr string
+	}{
+		{"unknown field", "rules:\n  - name: r\n    resourceType: [\"AWS::RDS::*\"]\n", "field resourceType not found"},
+		{"unknown effect", "rules:\n  - name: r\n    effect: block\n    actions: [Remove]\n", "unknown effect"},
+		{"no criteria", "rules:\n  - name: r\n    message: Nothing matches\n", "must match on at least one"},
+		{"unknown action", "rules:\n  - name: r\n    actions: [Remvoe]\n", `unknown action "Remvoe"`},
+		{"unknown replacement", "rules:\n  - name: r\n    replacement: [Yes]\n", `unknown replacement "Yes"`},
+		{"unknown scope", "rules:\n  - name: r\n    scope: [Tag]\n", `unknown scope "Tag"`},
+		{"invalid pattern", "rules:\n  - name: r\n    stacks: [\"prod-[\"]\n", "invalid pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePolicy([]byte(tt.policy))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParsePolicy() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParsePolicyDefaults(t *testing.T) {
+	policy, err := ParsePolicy([]byte("rules:\n  - actions: [remove, replace]\n  - name: tags\n    effect: warn\n    scope: [tags]\n"))
+	if err != nil {
+		t.Fatalf("ParsePolicy() error = %v", err)
+	}
+	if got := policy.Rules[0]; got.Name != "rule-1" || got.Effect != PolicyEffectDeny {
+		t.Errorf("first rule = %q, %q, want %q, %q", got.Name, got.Effect, "rule-1", PolicyEffectDeny)
+	}
+	if got := policy.Rules[1]; got.Name != "tags" || got.Effect != PolicyEffectWarn {
+		t.Errorf("second rule = %q, %q, want %q, %q", got.Name, got.Effect, "tags", PolicyEffectWarn)
+	}
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	changes := []types.Change{
+		testChange("Database", "AWS::RDS::DBInstance", types.ChangeActionModify, types.ReplacementConditional, testParameterDetail("DBInstanceClass", "DBInstanceClass")),
+		testChange("Table", "AWS::DynamoDB::Table", types.ChangeActionModify, types.ReplacementFalse, testParameterDetail("Environment", "Tags")),
+		testChange("Queue", "AWS::SQS::Queue", types.ChangeActionRemove, ""),
+		testChange("Instance", "AWS::EC2::Instance", types.ChangeActionModify, types.ReplacementTrue, testResourceDetail("Queue", "UserData")),
+		testChange("Topic", "AWS::SNS::Topic", types.ChangeActionAdd, ""),
+	}
+	tests := []struct {
+		name   string
+		policy string
+		// Ids of the matched changes, in order
+		want []string
+	}{
+		{
+			name:   "Replace matches conditional and certain replacements",
+			policy: "rules:\n  - actions: [Replace]\n",
+			want:   []string{"prod-app.Database", "prod-app.Instance"},
+		},
+		{
+			name:   "Replace does not match other modifications",
+			policy: "rules:\n  - actions: [Replace]\n    resourceTypes: [\"AWS::DynamoDB::*\"]\n",
+			want:   []string{},
+		},
+		{
+			name:   "actions",
+			policy: "rules:\n  - actions: [Remove, Add]\n",
+			want:   []string{"prod-app.Queue", "prod-app.Topic"},
+		},
+		{
+			name:   "actions ignore case",
+			policy: "rules:\n  - actions: [modify]\n    replacement: [false]\n",
+			want:   []string{"prod-app.Table"},
+		},
+		{
+			name:   "resource type patterns",
+			policy: "rules:\n  - resourceTypes: [\"AWS::RDS::*\", \"AWS::DynamoDB::*\"]\n    actions: [Remove, Modify]\n",
+			want:   []string{"prod-app.Database", "prod-app.Table"},
+		},
+		{
+			name:   "stack patterns",
+			policy: "rules:\n  - stacks: [\"prod-*\"]\n    actions: [Remove]\n  - stacks: [\"test-*\"]\n",
+			want:   []string{"prod-app.Queue"},
+		},
+		{
+			name:   "replacement",
+			policy: "rules:\n  - replacement: [True]\n",
+			want:   []string{"prod-app.Instance"},
+		},
+		{
+			name:   "scope",
+			policy: "rules:\n  - scope: [Properties]\n",
+			want:   []string{"prod-app.Database", "prod-app.Table", "prod-app.Instance"},
+		},
+		{
+			name:   "parameters",
+			policy: "rules:\n  - parameters: [Environment, InstanceType]\n",
+			want:   []string{"prod-app.Table"},
+		},
+		{
+			name:   "all criteria must match",
+			policy: "rules:\n  - parameters: [DBInstanceClass]\n    actions: [Remove]\n",
+			want:   []string{},
+		},
+		{
+			name:   "violations ordered by change and rule",
+			policy: "rules:\n  - actions: [Remove]\n  - resourceTypes: [\"AWS::SQS::*\", \"AWS::RDS::*\"]\n",
+			want:   []string{"prod-app.Database", "prod-app.Queue", "prod-app.Queue"},
+		},
+	}
+	model := newTestModel(t, testTree("prod-app", changes...))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := ParsePolicy([]byte(tt.policy))
+			if err != nil {
+				t.Fatalf("ParsePolicy() error = %v", err)
+			}
+			got := []string{}
+			for _, violation := range policy.Evaluate(model) {
+				got = append(got, violation.Resource.Id())
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("Evaluate() matched %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// How dangerous a change is
+type RiskLevel int
+
+const (
+	RiskLow RiskLevel = iota
+	RiskMedium
+	RiskHigh
+	RiskCritical
+)
+
+var riskLevelNames = []string{"low", "medium", "high", "critical"}
+
+func (l RiskLevel) String() string {
+	if l < RiskLow || l > RiskCritical {
+		return fmt.Sprintf("RiskLevel(%d)", int(l))
+	}
+	return riskLevelNames[l]
+}
+
+// Parse the name of a risk level ("low", "medium", "high", "critical")
+func ParseRiskLevel(s string) (RiskLevel, error) {
+	for i, name := range riskLevelNames {
+		if strings.EqualFold(s, name) {
+			return RiskLevel(i), nil
+		}
+	}
+	return RiskLow, fmt.Errorf("unknown risk level %q, must be one of %s", s, strings.Join(riskLevelNames, ", "))
+}
+
+// Resource types that hold data which is lost when the resource gets replaced or removed
+var statefulResourceTypes = map[string]bool{
+	"AWS::Backup::BackupVault":           true,
+	"AWS::Cognito::UserPool":             true,
+	"AWS::DocDB::DBCluster":              true,
+	"AWS::DocDB::DBInstance":             true,
+	"AWS::DynamoDB::GlobalTable":         true,
+	"AWS::DynamoDB::Table":               true,
+	"AWS::EC2::Volume":                   true,
+	"AWS::ECR::Repository":               true,
+	"AWS::EFS::FileSystem":               true,
+	"AWS::ElastiCache::CacheCluster":     true,
+	"AWS::ElastiCache::ReplicationGroup": true,
+	"AWS::Elasticsearch::Domain":         true,
+	"AWS::FSx::FileSystem":               true,
+	"AWS::Kinesis::Stream":               true,
+	"AWS::KMS::Key":                      true,
+	"AWS::Logs::LogGroup":                true,
+	"AWS::MSK::Cluster":                  true,
+	"AWS::Neptune::DBCluster":            true,
+	"AWS::Neptune::DBInstance":           true,
+	"AWS::OpenSearchService::Domain":     true,
+	"AWS::QLDB::Ledger":                  true,
+	"AWS::RDS::DBCluster":                true,
+	"AWS::RDS::DBInstance":               true,
+	"AWS::Redshift::Cluster":             true,
+	"AWS::S3::Bucket":                    true,
+	"AWS::SecretsManager::Secret":        true,
+	"AWS::SQS::Queue":                    true,
+	"AWS::Timestream::Table":             true,
+}
+
+// Whether resources of the given type hold data that is lost when they get replaced or removed
+func IsStatefulResourceType(resourceType string) bool {
+	return statefulResourceTypes[resourceType]
+}
+
+const nestedStackResourceType = "AWS::CloudFormation::Stack"
+
+// A rated change of a resource
+type Risk struct {
+	Resource *Resource
+	Level    RiskLevel
+	Reasons  []string
+}
+
+// Rate the change of a resource, taking the fate of removed and replaced resources into account
+//
+// The level depends on the action, whether the resource gets replaced, the scope of the change, and whether the
+// resource type is stateful. Stateful resources that are retained or snapshotted keep their data, and are rated like
+// any other resource. Resources without a change have a low risk and no reasons.
+func ClassifyResource(r *Resource) (RiskLevel, []string) {
+	rc := r.ResourceChange()
+	if rc == nil {
+		return RiskLow, nil
+	}
+	change := *rc
+	fate := r.Fate()
+	stateful := IsStatefulResourceType(aws.ToString(change.ResourceType)) && fate != ResourceFateRetained && fate != ResourceFateSnapshotted
+	level, reasons := classifyResourceChange(change, stateful)
+	if description := r.FateDescription(); description != "" {
+		reasons = append(reasons, description)
+	}
+	return level, reasons
+}
+
+func classifyResourceChange(change types.ResourceChange, stateful bool) (RiskLevel, []string) {
+	resourceType := aws.ToString(change.ResourceType)
+
+	switch change.Action {
+	case types.ChangeActionRemove:
+		switch {
+		case stateful:
+			return RiskCritical, []string{"removes a stateful resource"}
+		case resourceType == nestedStackResourceType:
+			return RiskHigh, []string{"removes a nested stack with all its resources"}
+		}
+		return RiskMedium, []string{"removes the resource"}
+	case types.ChangeActionModify:
+		switch change.Replacement {
+		case types.ReplacementTrue:
+			if stateful {
+				return RiskCritical, []string{"replaces a stateful resource"}
+			}
+			return RiskHigh, []string{"replaces the resource"}
+		case types.ReplacementConditional:
+			if stateful {
+				return RiskHigh, []string{"may replace a stateful resource"}
+			}
+			return RiskMedium, []string{"may replace the resource"}
+		}
+		if resourceType == nestedStackResourceType {
+			// The changes inside the nested stack are rated on their own
+			return RiskLow, nil
+		}
+		if contains(change.Scope, types.ResourceAttributeProperties) {
+			if stateful {
+				return RiskMedium, []string{"modifies properties of a stateful resource"}
+			}
+			return RiskLow, []string{"modifies properties"}
+		}
+		return RiskLow, nil
+	case types.ChangeActionDynamic:
+		if stateful {
+			return RiskMedium, []string{"stateful resource with changes that can only be determined during the update"}
+		}
+		return RiskLow, []string{"changes can only be determined during the update"}
+	}
+	return RiskLow, nil
+}
+
+// Rate all resource changes in this stack and its nested stacks, most dangerous first
+//
+// Risks with the same level keep the order of the stacks and changes.
+func (m *ChangeSetModel) Risks() []*Risk {
+	risks := []*Risk{}
+	m.Root.Walk(func(stack *Stack) error {
+		for _, r := range stack.Changes() {
+			if r.ResourceChange() == nil {
+				continue
+			}
+			level, reasons := ClassifyResource(r)
+			risks = append(risks, &Risk{Resource: r, Level: level, Reasons: reasons})
+		}
+		return nil
+	})
+	sort.SliceStable(risks, func(i, j int) bool {
+		return risks[i].Level > risks[j].Level
+	})
+	return risks
+}
@@ -0,0 +1,42 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func TestClassifyResource(t *testing.T) {
+	tests := []struct {
+		name   string
+		change types.Change
+		want   RiskLevel
+	}{
+		{"stateful removal", testChange("Database", "AWS::RDS::DBInstance", types.ChangeActionRemove, ""), RiskCritical},
+		{"stateful replacement", testChange("Table", "AWS::DynamoDB::Table", types.ChangeActionModify, types.ReplacementTrue), RiskCritical},
+		{"stateful conditional replacement", testChange("Table", "AWS::DynamoDB::Table", types.ChangeActionModify, types.ReplacementConditional), RiskHigh},
+		{"replacement", testChange("Instance", "AWS::EC2::Instance", types.ChangeActionModify, types.ReplacementTrue), RiskHigh},
+		{"nested stack removal", testChange("Network", "AWS::CloudFormation::Stack", types.ChangeActionRemove, ""), RiskHigh},
+		{"removal", testChange("Topic", "AWS::SNS::Topic", types.ChangeActionRemove, ""), RiskMedium},
+		{"addition", testChange("Topic", "AWS::SNS::Topic", types.ChangeActionAdd, ""), RiskLow},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := newTestModel(t, testTree("Stack", tt.change))
+			if got, _ := ClassifyResource(model.Root.Resources[0]); got != tt.want {
+				t.Errorf("ClassifyResource() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClassifyResourceWithoutChange(t *testing.T) {
+	// The subnet is only known as the cause of the change of the instance
+	model := newTestModel(t, testTree("Stack",
+		testChange("Instance", "AWS::EC2::Instance", types.ChangeActionModify, types.ReplacementTrue, testResourceDetail("Subnet", "SubnetId")),
+	))
+	level, reasons := ClassifyResource(model.Root.FindResource("Subnet"))
+	if level != RiskLow || reasons != nil {
+		t.Errorf("ClassifyResource() = %v, %q, want %v, no reasons", level, reasons, RiskLow)
+	}
+}
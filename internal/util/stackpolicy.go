@@ -0,0 +1,243 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// Actions of stack policy statements
+const (
+	StackPolicyActionModify  = "Update:Modify"
+	StackPolicyActionReplace = "Update:Replace"
+	StackPolicyActionDelete  = "Update:Delete"
+)
+
+// The only condition key supported by stack policies
+const stackPolicyConditionResourceType = "ResourceType"
+
+// A stack policy, protecting the resources of a stack from updates
+//
+// See https://docs.aws.amazon.com/AWSCloudFormation/latest/UserGuide/protect-stack-resources.html
+type StackPolicy struct {
+	Statement []*StackPolicyStatement `json:"Statement"`
+}
+
+// A statement of a stack policy
+//
+// Resources are given as "LogicalResourceId/Name", and both actions and resources can use "*" and "?" wildcards.
+type StackPolicyStatement struct {
+	Effect      string       `json:"Effect"`
+	Action      stringOrList `json:"Action"`
+	NotAction   stringOrList `json:"NotAction"`
+	Principal   interface{}  `json:"Principal"`
+	Resource    stringOrList `json:"Resource"`
+	NotResource stringOrList `json:"NotResource"`
+	// Conditions on the resource type, indexed by the operator and the key
+	Condition map[string]map[string]stringOrList `json:"Condition"`
+}
+
+// A JSON value that is either a single string or a list of strings
+type stringOrList []string
+
+func (l *stringOrList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = []string{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("must be a string or a list of strings")
+	}
+	*l = list
+	return nil
+}
+
+func (st *StackPolicyStatement) validate() error {
+	if st.Effect != "Allow" && st.Effect != "Deny" {
+		return fmt.Errorf("unknown effect %q, must be \"Allow\" or \"Deny\"", st.Effect)
+	}
+	if len(st.Action) == 0 && len(st.NotAction) == 0 {
+		return fmt.Errorf("must have Action or NotAction")
+	}
+	if len(st.Resource) == 0 && len(st.NotResource) == 0 {
+		return fmt.Errorf("must have Resource or NotResource")
+	}
+	for operator, conditions := range st.Condition {
+		switch operator {
+		case "StringEquals", "StringNotEquals", "StringLike", "StringNotLike":
+		default:
+			return fmt.Errorf("unsupported condition operator %q", operator)
+		}
+		for key := range conditions {
+			if key != stackPolicyConditionResourceType {
+				return fmt.Errorf("unsupported condition key %q, must be %q", key, stackPolicyConditionResourceType)
+			}
+		}
+	}
+	return nil
+}
+
+// Parse the JSON body of a stack policy
+func ParseStackPolicy(body string) (*StackPolicy, error) {
+	policy := &StackPolicy{}
+	if err := json.Unmarshal([]byte(body), policy); err != nil {
+		return nil, fmt.Errorf("cannot parse stack policy, %v", err)
+	}
+	for i, st := range policy.Statement {
+		if err := st.validate(); err != nil {
+			return nil, fmt.Errorf("invalid statement %d of stack policy, %v", i+1, err)
+		}
+	}
+	return policy, nil
+}
+
+// Whether the pattern with "*" and "?" wildcards matches the whole string
+//
+// Unlike with path.Match the wildcards also match "/", as in IAM policies, and there are no other special characters.
+func matchesWildcard(pattern string, s string, ignoreCase bool) bool {
+	if ignoreCase {
+		pattern, s = strings.ToLower(pattern), strings.ToLower(s)
+	}
+	p, t := []rune(pattern), []rune(s)
+	// The position of the last "*" in the pattern, and the position in the string from which it matches
+	star, starMatch := -1, 0
+	i, j := 0, 0
+	for j < len(t) {
+		switch {
+		case i < len(p) && p[i] == '*':
+			star, starMatch = i, j
+			i++
+		case i < len(p) && (p[i] == '?' || p[i] == t[j]):
+			i++
+			j++
+		case star >= 0:
+			// Let the last "*" match one more character
+			starMatch++
+			i, j = star+1, starMatch
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
+
+func matchesAnyWildcard(patterns []string, s string, ignoreCase bool) bool {
+	for _, pattern := range patterns {
+		if matchesWildcard(pattern, s, ignoreCase) {
+			return true
+		}
+	}
+	return false
+}
+
+func (st *StackPolicyStatement) matchesAction(action string) bool {
+	if len(st.Action) > 0 {
+		return matchesAnyWildcard(st.Action, action, true)
+	}
+	return !matchesAnyWildcard(st.NotAction, action, true)
+}
+
+func (st *StackPolicyStatement) matchesResource(logicalResourceId string) bool {
+	resource := fmt.Sprintf("LogicalResourceId/%s", logicalResourceId)
+	if len(st.Resource) > 0 {
+		return matchesAnyWildcard(st.Resource, resource, false)
+	}
+	return !matchesAnyWildcard(st.NotResource, resource, false)
+}
+
+func (st *StackPolicyStatement) matchesCondition(resourceType string) bool {
+	for operator, conditions := range st.Condition {
+		for _, values := range conditions {
+			var matched bool
+			switch operator {
+			case "StringEquals", "StringNotEquals":
+				matched = contains(values, resourceType)
+			case "StringLike", "StringNotLike":
+				matched = matchesAnyWildcard(values, resourceType, false)
+			}
+			if strings.HasPrefix(operator, "StringNot") {
+				matched = !matched
+			}
+			if !matched {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Whether the statement applies to the action on the resource
+func (st *StackPolicyStatement) Matches(action string, logicalResourceId string, resourceType string) bool {
+	return st.matchesAction(action) && st.matchesResource(logicalResourceId) && st.matchesCondition(resourceType)
+}
+
+// Whether the policy allows the action on the resource, with the reason for the decision
+//
+// An explicit "Deny" wins over any "Allow", and actions that no statement allows are denied.
+func (p *StackPolicy) Evaluate(action string, logicalResourceId string, resourceType string) (bool, string) {
+	allowedBy := 0
+	for i, st := range p.Statement {
+		if !st.Matches(action, logicalResourceId, resourceType) {
+			continue
+		}
+		if st.Effect == "Deny" {
+			return false, fmt.Sprintf("%s denied by statement %d", action, i+1)
+		}
+		if allowedBy == 0 {
+			allowedBy = i + 1
+		}
+	}
+	if allowedBy == 0 {
+		return false, fmt.Sprintf("%s not allowed by any statement", action)
+	}
+	return true, fmt.Sprintf("%s allowed by statement %d", action, allowedBy)
+}
+
+// The prediction of the stack policy for a change
+type StackPolicyVerdict string
+
+const (
+	StackPolicyBlocked           StackPolicyVerdict = "blocked"
+	StackPolicyBlockedIfReplaced StackPolicyVerdict = "blocked if replaced"
+)
+
+// Whether the stack policy will block the change of the resource, and why
+//
+// The verdict is empty when the change is allowed, when the stack has no policy, and for changes that
+// stack policies don't cover (additions, imports and dynamic changes). Modifications that might replace the
+// resource are checked both as modification and as replacement.
+func (r *Resource) StackPolicyVerdict() (StackPolicyVerdict, string) {
+	rc := r.ResourceChange()
+	policy := r.Stack.StackPolicy
+	if rc == nil || policy == nil {
+		return "", ""
+	}
+
+	var action string
+	switch {
+	case rc.Action == types.ChangeActionRemove:
+		action = StackPolicyActionDelete
+	case rc.Action == types.ChangeActionModify && rc.Replacement == types.ReplacementTrue:
+		action = StackPolicyActionReplace
+	case rc.Action == types.ChangeActionModify:
+		action = StackPolicyActionModify
+	default:
+		return "", ""
+	}
+	if allowed, reason := policy.Evaluate(action, r.LogicalResourceId, r.ResourceType()); !allowed {
+		return StackPolicyBlocked, reason
+	}
+	if rc.Action == types.ChangeActionModify && rc.Replacement == types.ReplacementConditional {
+		if allowed, reason := policy.Evaluate(StackPolicyActionReplace, r.LogicalResourceId, r.ResourceType()); !allowed {
+			return StackPolicyBlockedIfReplaced, reason
+		}
+	}
+	return "", ""
+}
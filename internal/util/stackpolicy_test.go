@@ -0,0 +1,299 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func TestMatchesWildcard(t *testing.T) {
+	tests := []struct {
+		pattern    string
+		s          string
+		ignoreCase bool
+		want       bool
+	}{
+		{"*", "", false, true},
+		{"*", "LogicalResourceId/Database", false, true},
+		{"LogicalResourceId/Database", "LogicalResourceId/Database", false, true},
+		{"LogicalResourceId/Database", "LogicalResourceId/DatabaseReplica", false, false},
+		{"LogicalResourceId/Prod*", "LogicalResourceId/ProdTable", false, true},
+		{"LogicalResourceId/Prod*", "LogicalResourceId/TestTable", false, false},
+		{"LogicalResourceId/Prod*", "LogicalResourceId/prodTable", false, false},
+		{"AWS::S??::*", "AWS::SQS::Queue", false, true},
+		{"AWS::S??::*", "AWS::S3::Bucket", false, false},
+		// Other regular expression characters are literal
+		{"AWS::RDS::DB.*", "AWS::RDS::DBInstance", false, false},
+		{"AWS::RDS::DB.*", "AWS::RDS::DB.Instance", false, true},
+		{"Update:*", "Update:Replace", true, true},
+		{"update:modify", "Update:Modify", true, true},
+		{"update:modify", "Update:Modify", false, false},
+		{"*Table", "LogicalResourceId/ProdTable", false, true},
+		{"*Table*", "LogicalResourceId/TableTableX", false, true},
+		{"*a*b", "aXbYa", false, false},
+		{"a**b", "ab", false, true},
+		{"?", "", false, false},
+		{"", "", false, true},
+		{"[ab]", "a", false, false},
+		{"[ab]", "[ab]", false, true},
+	}
+	for _, tt := range tests {
+		if got := matchesWildcard(tt.pattern, tt.s, tt.ignoreCase); got != tt.want {
+			t.Errorf("matchesWildcard(%q, %q, %v) = %v, want %v", tt.pattern, tt.s, tt.ignoreCase, got, tt.want)
+		}
+	}
+}
+
+func TestParseStackPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"invalid JSON", `{"Statement": [`},
+		{"unknown effect", `{"Statement": [{"Effect": "Block", "Action": "Update:*", "Principal": "*", "Resource": "*"}]}`},
+		{"no action", `{"Statement": [{"Effect": "Allow", "Principal": "*", "Resource": "*"}]}`},
+		{"no resource", `{"Statement": [{"Effect": "Allow", "Action": "Update:*", "Principal": "*"}]}`},
+		{"action not a string", `{"Statement": [{"Effect": "Allow", "Action": 1, "Principal": "*", "Resource": "*"}]}`},
+		{"unsupported operator", `{"Statement": [{"Effect": "Deny", "Action": "Update:*", "Principal": "*", "Resource": "*", "Condition": {"IpAddress": {"ResourceType": "AWS::RDS::DBInstance"}}}]}`},
+		{"unsupported key", `{"Statement": [{"Effect": "Deny", "Action": "Update:*", "Principal": "*", "Resource": "*", "Condition": {"StringEquals": {"aws:SourceIp": "10.0.0.1"}}}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseStackPolicy(tt.body); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func mustParseStackPolicy(t *testing.T, body string) *StackPolicy {
+	t.Helper()
+	policy, err := ParseStackPolicy(body)
+	if err != nil {
+		t.Fatalf("cannot parse stack policy, %v", err)
+	}
+	return policy
+}
+
+func TestStackPolicyEvaluate(t *testing.T) {
+	tests := []struct {
+		name         string
+		policy       string
+		action       string
+		resource     string
+		resourceType string
+		wantAllowed  bool
+		wantReason   string
+	}{
+		{
+			name:         "allow all",
+			policy:       `{"Statement": [{"Effect": "Allow", "Action": "Update:*", "Principal": "*", "Resource": "*"}]}`,
+			action:       StackPolicyActionReplace,
+			resource:     "Database",
+			resourceType: "AWS::RDS::DBInstance",
+			wantAllowed:  true,
+			wantReason:   "Update:Replace allowed by statement 1",
+		},
+		{
+			name:         "no statement",
+			policy:       `{"Statement": []}`,
+			action:       StackPolicyActionModify,
+			resource:     "Database",
+			resourceType: "AWS::RDS::DBInstance",
+			wantAllowed:  false,
+			wantReason:   "Update:Modify not allowed by any statement",
+		},
+		{
+			name: "explicit deny wins",
+			policy: `{"Statement": [
+				{"Effect": "Deny", "Action": "Update:Delete", "Principal": "*", "Resource": "LogicalResourceId/Database"},
+				{"Effect": "Allow", "Action": "Update:*", "Principal": "*", "Resource": "*"}
+			]}`,
+			action:       StackPolicyActionDelete,
+			resource:     "Database",
+			resourceType: "AWS::RDS::DBInstance",
+			wantAllowed:  false,
+			wantReason:   "Update:Delete denied by statement 1",
+		},
+		{
+			name: "deny of other resource",
+			policy: `{"Statement": [
+				{"Effect": "Allow", "Action": "Update:*", "Principal": "*", "Resource": "*"},
+				{"Effect": "Deny", "Action": "Update:Delete", "Principal": "*", "Resource": "LogicalResourceId/Database"}
+			]}`,
+			action:       StackPolicyActionDelete,
+			resource:     "Queue",
+			resourceType: "AWS::SQS::Queue",
+			wantAllowed:  true,
+			wantReason:   "Update:Delete allowed by statement 1",
+		},
+		{
+			name: "action list",
+			policy: `{"Statement": [
+				{"Effect": "Allow", "Action": "Update:*", "Principal": "*", "Resource": "*"},
+				{"Effect": "Deny", "Action": ["Update:Replace", "Update:Delete"], "Principal": "*", "Resource": "*"}
+			]}`,
+			action:       StackPolicyActionReplace,
+			resource:     "Queue",
+			resourceType: "AWS::SQS::Queue",
+			wantAllowed:  false,
+			wantReason:   "Update:Replace denied by statement 2",
+		},
+		{
+			name: "NotAction",
+			policy: `{"Statement": [
+				{"Effect": "Allow", "Action": "Update:*", "Principal": "*", "Resource": "*"},
+				{"Effect": "Deny", "NotAction": "Update:Modify", "Principal": "*", "Resource": "*"}
+			]}`,
+			action:       StackPolicyActionModify,
+			resource:     "Queue",
+			resourceType: "AWS::SQS::Queue",
+			wantAllowed:  true,
+			wantReason:   "Update:Modify allowed by statement 1",
+		},
+		{
+			name: "NotAction denies other actions",
+			policy: `{"Statement": [
+				{"Effect": "Allow", "Action": "Update:*", "Principal": "*", "Resource": "*"},
+				{"Effect": "Deny", "NotAction": "Update:Modify", "Principal": "*", "Resource": "*"}
+			]}`,
+			action:       StackPolicyActionReplace,
+			resource:     "Queue",
+			resourceType: "AWS::SQS::Queue",
+			wantAllowed:  false,
+			wantReason:   "Update:Replace denied by statement 2",
+		},
+		{
+			name: "NotResource",
+			policy: `{"Statement": [
+				{"Effect": "Allow", "Action": "Update:*", "Principal": "*", "NotResource": "LogicalResourceId/Prod*"}
+			]}`,
+			action:       StackPolicyActionModify,
+			resource:     "ProdTable",
+			resourceType: "AWS::DynamoDB::Table",
+			wantAllowed:  false,
+			wantReason:   "Update:Modify not allowed by any statement",
+		},
+		{
+			name: "StringEquals condition",
+			policy: `{"Statement": [
+				{"Effect": "Allow", "Action": "Update:*", "Principal": "*", "Resource": "*"},
+				{"Effect": "Deny", "Action": "Update:Replace", "Principal": "*", "Resource": "*", "Condition": {"StringEquals": {"ResourceType": ["AWS::RDS::DBInstance", "AWS::DynamoDB::Table"]}}}
+			]}`,
+			action:       StackPolicyActionReplace,
+			resource:     "Table",
+			resourceType: "AWS::DynamoDB::Table",
+			wantAllowed:  false,
+			wantReason:   "Update:Replace denied by statement 2",
+		},
+		{
+			name: "StringLike condition",
+			policy: `{"Statement": [
+				{"Effect": "Allow", "Action": "Update:*", "Principal": "*", "Resource": "*", "Condition": {"StringLike": {"ResourceType": "AWS::EC2::*"}}}
+			]}`,
+			action:       StackPolicyActionReplace,
+			resource:     "Instance",
+			resourceType: "AWS::EC2::Instance",
+			wantAllowed:  true,
+			wantReason:   "Update:Replace allowed by statement 1",
+		},
+		{
+			name: "StringLike condition not matching",
+			policy: `{"Statement": [
+				{"Effect": "Allow", "Action": "Update:*", "Principal": "*", "Resource": "*", "Condition": {"StringLike": {"ResourceType": "AWS::EC2::*"}}}
+			]}`,
+			action:       StackPolicyActionReplace,
+			resource:     "Database",
+			resourceType: "AWS::RDS::DBInstance",
+			wantAllowed:  false,
+			wantReason:   "Update:Replace not allowed by any statement",
+		},
+		{
+			name: "StringNotLike condition",
+			policy: `{"Statement": [
+				{"Effect": "Allow", "Action": "Update:*", "Principal": "*", "Resource": "*"},
+				{"Effect": "Deny", "Action": "Update:*", "Principal": "*", "Resource": "*", "Condition": {"StringNotLike": {"ResourceType": "AWS::EC2::*"}}}
+			]}`,
+			action:       StackPolicyActionModify,
+			resource:     "Instance",
+			resourceType: "AWS::EC2::Instance",
+			wantAllowed:  true,
+			wantReason:   "Update:Modify allowed by statement 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := mustParseStackPolicy(t, tt.policy)
+			allowed, reason := policy.Evaluate(tt.action, tt.resource, tt.resourceType)
+			if allowed != tt.wantAllowed || reason != tt.wantReason {
+				t.Errorf("Evaluate() = %v, %q, want %v, %q", allowed, reason, tt.wantAllowed, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestStackPolicyVerdict(t *testing.T) {
+	const denyDataStores = `{"Statement": [
+		{"Effect": "Allow", "Action": "Update:*", "Principal": "*", "Resource": "*"},
+		{"Effect": "Deny", "Action": ["Update:Replace", "Update:Delete"], "Principal": "*", "Resource": "*", "Condition": {"StringEquals": {"ResourceType": "AWS::RDS::DBInstance"}}}
+	]}`
+	tests := []struct {
+		name        string
+		policy      string
+		change      types.Change
+		wantVerdict StackPolicyVerdict
+		wantReason  string
+	}{
+		{
+			name:        "replacement",
+			policy:      denyDataStores,
+			change:      testChange("Database", "AWS::RDS::DBInstance", types.ChangeActionModify, types.ReplacementTrue),
+			wantVerdict: StackPolicyBlocked,
+			wantReason:  "Update:Replace denied by statement 2",
+		},
+		{
+			name:        "conditional replacement",
+			policy:      denyDataStores,
+			change:      testChange("Database", "AWS::RDS::DBInstance", types.ChangeActionModify, types.ReplacementConditional),
+			wantVerdict: StackPolicyBlockedIfReplaced,
+			wantReason:  "Update:Replace denied by statement 2",
+		},
+		{
+			name:   "modification",
+			policy: denyDataStores,
+			change: testChange("Database", "AWS::RDS::DBInstance", types.ChangeActionModify, types.ReplacementFalse),
+		},
+		{
+			name:        "removal",
+			policy:      denyDataStores,
+			change:      testChange("Database", "AWS::RDS::DBInstance", types.ChangeActionRemove, ""),
+			wantVerdict: StackPolicyBlocked,
+			wantReason:  "Update:Delete denied by statement 2",
+		},
+		{
+			name:   "other resource type",
+			policy: denyDataStores,
+			change: testChange("Queue", "AWS::SQS::Queue", types.ChangeActionRemove, ""),
+		},
+		{
+			name:   "addition",
+			policy: `{"Statement": []}`,
+			change: testChange("Database", "AWS::RDS::DBInstance", types.ChangeActionAdd, ""),
+		},
+		{
+			name:   "no stack policy",
+			change: testChange("Database", "AWS::RDS::DBInstance", types.ChangeActionRemove, ""),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := testTree("Stack", tt.change)
+			tree.StackPolicyBody = tt.policy
+			model := newTestModel(t, tree)
+			verdict, reason := model.Root.Resources[0].StackPolicyVerdict()
+			if verdict != tt.wantVerdict || reason != tt.wantReason {
+				t.Errorf("StackPolicyVerdict() = %q, %q, want %q, %q", verdict, reason, tt.wantVerdict, tt.wantReason)
+			}
+		})
+	}
+}
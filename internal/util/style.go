@@ -0,0 +1,354 @@
+package util
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+// Colors and labels shared by all graph renderers
+
+type color = string
+
+// Options for rendering graphs
+type GraphOpts struct {
+	// Show all parameters of each stack, not only those that cause changes or change their value
+	AllParameters bool
+}
+
+// The parameters of the stack to show, split into those that cause changes and those that don't
+func (opts *GraphOpts) splitParameters(stack *Stack) (used []*Parameter, unused []*Parameter) {
+	for _, p := range stack.Parameters {
+		if len(p.Effects) > 0 {
+			used = append(used, p)
+		} else if p.Changed() || (opts != nil && opts.AllParameters) {
+			unused = append(unused, p)
+		}
+	}
+	return used, unused
+}
+
+const (
+	modifiedResourceColor color = "/paired10/2"
+	addedResourceColor    color = "/paired10/4"
+	removedResourceColor  color = "/paired10/6"
+	importedResourceColor color = "/paired10/8"
+	dynamicResourceColor  color = "/paired10/12"
+
+	unusedParameterColor color = "/paired10/9"
+	usedParameterColor   color = "/paired10/10"
+	outputColor          color = "/paired10/7"
+
+	maybeReplacedResourceFillColor color = "/paired10/1"
+	replacedResourceFillColor      color = "/paired10/2"
+	removedResourceFillColor       color = "/paired10/5"
+
+	alwaysRecreationColor      color = "/paired10/6"
+	conditionalRecreationColor color = "/paired10/8"
+
+	diffAddedColor     color = "/paired10/4"
+	diffRemovedColor   color = "/paired10/6"
+	diffChangedColor   color = "/paired10/8"
+	diffUnchangedColor color = "gray"
+
+	failedStackColor     color = "red"
+	failedStackFillColor color = "mistyrose"
+
+	// Pen width for the border of drifted resources that get changed
+	driftedPenWidth = 3
+	// Shape for changes that the stack policy blocks
+	blockedResourceShape = "octagon"
+	// Style for nodes standing in for changes left out by filters
+	elidedResourceStyle = "dashed"
+
+	parametersNodeName       = "Parameters"
+	unusedParametersNodeName = "UnusedParameters"
+	outputsNodeName          = "Outputs"
+	stackNodeName            = "_"
+)
+
+// The colors of the "paired" color brewer scheme
+var pairedColors = []string{
+	"#a6cee3", "#1f78b4", "#b2df8a", "#33a02c", "#fb9a99", "#e31a1c",
+	"#fdbf6f", "#ff7f00", "#cab2d6", "#6a3d9a", "#ffff99", "#b15928",
+}
+
+// Convert a "/pairedN/I" color into a hex color for renderers that don't know about color schemes
+func colorToHex(c color) string {
+	parts := strings.Split(c, "/")
+	if len(parts) != 3 || !strings.HasPrefix(parts[1], "paired") {
+		return c
+	}
+	index, err := strconv.Atoi(parts[2])
+	if err != nil || index < 1 || index > len(pairedColors) {
+		return c
+	}
+	return pairedColors[index-1]
+}
+
+// The border and fill color for a resource change
+func resourceChangeColors(change types.ResourceChange) (border color, fill color) {
+	switch change.Replacement {
+	case types.ReplacementTrue:
+		fill = replacedResourceFillColor
+	case types.ReplacementConditional:
+		fill = maybeReplacedResourceFillColor
+	}
+
+	switch change.Action {
+	case types.ChangeActionAdd:
+		border = addedResourceColor
+	case types.ChangeActionRemove:
+		border = removedResourceColor
+		fill = removedResourceFillColor
+	case types.ChangeActionModify:
+		border = modifiedResourceColor
+	case types.ChangeActionImport:
+		border = importedResourceColor
+	case types.ChangeActionDynamic:
+		border = dynamicResourceColor
+	}
+	return
+}
+
+// A short prefix for the action of a change ("+" for additions, "-" for removals, ...)
+func changeActionPrefix(action types.ChangeAction) string {
+	switch action {
+	case types.ChangeActionAdd:
+		return "+"
+	case types.ChangeActionRemove:
+		return "-"
+	case types.ChangeActionModify:
+		return "~"
+	case types.ChangeActionImport:
+		return "*"
+	case types.ChangeActionDynamic:
+		return "?"
+	}
+	return ""
+}
+
+// The label of a resource change, split into lines
+//
+// Changes with at least a medium risk get annotated with the risk level, removed and replaced resources with
+// their fate.
+func resourceChangeLabel(r *Resource) []string {
+	change := r.ResourceChange()
+	lines := []string{
+		fmt.Sprintf("%s %s", changeActionPrefix(change.Action), r.LogicalResourceId),
+		aws.ToString(change.ResourceType),
+	}
+	if level, _ := ClassifyResource(r); level >= RiskMedium {
+		lines = append(lines, fmt.Sprintf("[%s risk]", level))
+	}
+	if description := r.FateDescription(); description != "" {
+		lines = append(lines, description)
+	}
+	return lines
+}
+
+// Split text into lines of at most width characters, breaking at spaces where possible
+func wrapText(s string, width int) []string {
+	lines := []string{}
+	line := ""
+	for _, word := range strings.Fields(s) {
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// The label of a resource, split into lines
+//
+// This includes the hooks invoked for the change, and for nested stacks that could not be processed the error.
+func resourceLabel(r *Resource) []string {
+	var lines []string
+	switch {
+	case len(r.Elided) > 0:
+		lines = []string{"…"}
+	case r.Change == nil:
+		lines = []string{r.LogicalResourceId}
+	case r.Change.Type != types.ChangeTypeResource || r.ResourceChange() == nil:
+		// Not a resource change: Show the type of the change so that it at least is visible
+		lines = []string{r.LogicalResourceId, fmt.Sprintf("(%s change)", r.Change.Type)}
+	default:
+		lines = resourceChangeLabel(r)
+	}
+	for _, hook := range r.Hooks {
+		lines = append(lines, fmt.Sprintf("Hook: %s", hook))
+	}
+	if len(r.Hooks) == 0 && r.HookInvocationCount() > 0 {
+		lines = append(lines, fmt.Sprintf("Hooks: %d invocation(s)", r.HookInvocationCount()))
+	}
+	if r.DriftedChange() {
+		lines = append(lines, fmt.Sprintf("Drifted: %s", r.Drift.StackResourceDriftStatus))
+	}
+	if verdict, _ := r.StackPolicyVerdict(); verdict != "" {
+		lines = append(lines, fmt.Sprintf("Stack policy: %s", verdict))
+	}
+	if r.NestedStack != nil && r.NestedStack.Err != nil {
+		lines = append(lines, "Error:")
+		lines = append(lines, wrapText(r.NestedStack.Err.Error(), 60)...)
+	}
+	return lines
+}
+
+// The tooltip of a resource with the values of the changed properties, the drift and the stack policy verdict,
+// empty if they are not known
+//
+// For nodes standing in for left out changes this lists the left out resources.
+func resourceTooltip(r *Resource) string {
+	lines := []string{}
+	if len(r.Elided) > 0 {
+		lines = append(lines, fmt.Sprintf("Left out by filters: %s", strings.Join(r.Elided, ", ")))
+	}
+	for _, c := range r.PropertyChanges() {
+		lines = append(lines, c.String())
+	}
+	if r.DriftedChange() {
+		for _, d := range r.DriftDescriptions() {
+			lines = append(lines, fmt.Sprintf("Drift: %s", d))
+		}
+	}
+	if verdict, reason := r.StackPolicyVerdict(); verdict != "" {
+		lines = append(lines, fmt.Sprintf("Stack policy: %s, %s", verdict, reason))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Shorten text to at most width characters
+func truncateText(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+// The label of a parameter, with the old and the new value if the value changes
+//
+// Values of NoEcho parameters are never shown.
+func parameterLabel(p *Parameter) string {
+	label := p.Name
+	if p.Changed() {
+		previous := "(not set)"
+		if p.HasPreviousValue {
+			previous = truncateText(p.PreviousValue, 30)
+		}
+		label = fmt.Sprintf("%s: %s → %s", label, previous, truncateText(p.Value, 30))
+	}
+	return label
+}
+
+// A field of a record node, connected to edges through its port (if any)
+type recordField struct {
+	port  string
+	label string
+}
+
+// Escape the characters with a special meaning in labels of record nodes
+func escapeRecordLabel(s string) string {
+	var sb strings.Builder
+	for _, c := range s {
+		if strings.ContainsRune(`\{}|<>`, c) {
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// The record label with one field per port
+func recordLabel(fields []recordField) string {
+	specs := []string{}
+	for _, field := range fields {
+		if field.port == "" {
+			specs = append(specs, escapeRecordLabel(field.label))
+		} else {
+			specs = append(specs, fmt.Sprintf("<%s>%s", field.port, escapeRecordLabel(field.label)))
+		}
+	}
+	return strings.Join(specs, "|")
+}
+
+// The fields of the record node for the parameters
+func parameterRecordFields(parameters []*Parameter) []recordField {
+	fields := []recordField{}
+	for _, parameter := range parameters {
+		fields = append(fields, recordField{parameter.Name, parameterLabel(parameter)})
+	}
+	return fields
+}
+
+// The fields of the record node for the parameters that cause no changes, with a heading
+func unusedParameterRecordFields(parameters []*Parameter) []recordField {
+	if len(parameters) == 0 {
+		return []recordField{}
+	}
+	return append([]recordField{{"", "Causes no changes"}}, parameterRecordFields(parameters)...)
+}
+
+// The fields of the record node for the outputs of the nested stack
+func outputRecordFields(stack *Stack) []recordField {
+	fields := []recordField{}
+	for _, output := range stack.ReferencedOutputs() {
+		fields = append(fields, recordField{output, output})
+	}
+	return fields
+}
+
+// The color and arrowhead of a cause edge, showing whether the change forces a replacement of the changed resource
+//
+// Both are empty for changes that never require recreating the resource.
+func recreationEdgeStyle(cause *Cause) (color, string) {
+	switch cause.RequiresRecreation {
+	case types.RequiresRecreationAlways:
+		return alwaysRecreationColor, "diamond"
+	case types.RequiresRecreationConditionally:
+		return conditionalRecreationColor, "odiamond"
+	}
+	return "", ""
+}
+
+// The tooltip of a cause edge with the evaluation, and whether the change requires recreating the resource
+func causeEdgeTooltip(cause *Cause) string {
+	parts := []string{}
+	switch cause.Evaluation {
+	case types.EvaluationTypeStatic:
+		parts = append(parts, "Static evaluation")
+	case types.EvaluationTypeDynamic:
+		parts = append(parts, "Dynamic evaluation")
+	}
+	switch cause.RequiresRecreation {
+	case types.RequiresRecreationAlways:
+		parts = append(parts, "requires recreation")
+	case types.RequiresRecreationConditionally:
+		parts = append(parts, "may require recreation")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// The label for the changed end of a cause edge, empty if there is nothing worth showing
+func causeTargetLabel(cause *Cause) string {
+	// XXX: "Parameters" is pretty much the default for nested stacks, and just adds noise. But, is this check
+	//      enough, or could this now catch and hide user-defined things called
+	if cause.TargetAttribute == types.ResourceAttributeProperties {
+		if cause.TargetName != "Parameters" {
+			return cause.TargetName
+		}
+		return ""
+	}
+	return string(cause.TargetAttribute)
+}
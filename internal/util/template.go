@@ -0,0 +1,197 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A parsed CloudFormation template
+//
+// Short-form intrinsic functions of YAML templates ("!Ref", "!GetAtt", ...) are converted into their full form, so
+// that templates look the same regardless of their format.
+type Template struct {
+	Parameters map[string]*TemplateParameter `json:"Parameters"`
+	Resources  map[string]*TemplateResource  `json:"Resources"`
+}
+
+// A parameter in a template
+type TemplateParameter struct {
+	// Either a boolean or a string
+	NoEcho interface{} `json:"NoEcho"`
+}
+
+// A resource in a template
+type TemplateResource struct {
+	Type       string                 `json:"Type"`
+	Properties map[string]interface{} `json:"Properties"`
+	// Either a string, or an unresolved intrinsic function
+	DeletionPolicy      interface{} `json:"DeletionPolicy"`
+	UpdateReplacePolicy interface{} `json:"UpdateReplacePolicy"`
+}
+
+// Whether the tag is a short-form intrinsic function, rather than a standard YAML tag ("!!str", ...)
+func isIntrinsicFunctionTag(tag string) bool {
+	return strings.HasPrefix(tag, "!") && !strings.HasPrefix(tag, "!!")
+}
+
+// Convert a YAML node into plain values, expanding short-form intrinsic functions
+func yamlNodeValue(node *yaml.Node) (interface{}, error) {
+	var value interface{}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return yamlNodeValue(node.Content[0])
+	case yaml.AliasNode:
+		return yamlNodeValue(node.Alias)
+	case yaml.MappingNode:
+		m := map[string]interface{}{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			v, err := yamlNodeValue(node.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			m[node.Content[i].Value] = v
+		}
+		value = m
+	case yaml.SequenceNode:
+		l := []interface{}{}
+		for _, item := range node.Content {
+			v, err := yamlNodeValue(item)
+			if err != nil {
+				return nil, err
+			}
+			l = append(l, v)
+		}
+		value = l
+	case yaml.ScalarNode:
+		if isIntrinsicFunctionTag(node.Tag) {
+			// The argument of the function, the tag cannot be decoded
+			value = node.Value
+		} else if err := node.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+
+	if !isIntrinsicFunctionTag(node.Tag) {
+		return value, nil
+	}
+	switch name := strings.TrimPrefix(node.Tag, "!"); name {
+	case "Ref", "Condition":
+		return map[string]interface{}{name: value}, nil
+	case "GetAtt":
+		// "!GetAtt Resource.Attribute" is the short form of a list
+		if s, ok := value.(string); ok {
+			resource, attribute, _ := strings.Cut(s, ".")
+			value = []interface{}{resource, attribute}
+		}
+		return map[string]interface{}{"Fn::GetAtt": value}, nil
+	default:
+		return map[string]interface{}{fmt.Sprintf("Fn::%s", name): value}, nil
+	}
+}
+
+// Parse the body of a template in JSON or YAML format
+func ParseTemplate(body string) (*Template, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(body), &node); err != nil {
+		return nil, fmt.Errorf("cannot parse template, %v", err)
+	}
+	value, err := yamlNodeValue(&node)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse template, %v", err)
+	}
+
+	// Round-trip through JSON to get the typed structure
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("cannot convert template, %v", err)
+	}
+	template := &Template{}
+	if err := json.Unmarshal(data, template); err != nil {
+		return nil, fmt.Errorf("cannot convert template, %v", err)
+	}
+	return template, nil
+}
+
+// Whether the template declares the parameter with `NoEcho`
+func (t *Template) IsNoEchoParameter(name string) bool {
+	if t == nil || t.Parameters[name] == nil {
+		return false
+	}
+	switch noEcho := t.Parameters[name].NoEcho.(type) {
+	case bool:
+		return noEcho
+	case string:
+		return strings.EqualFold(noEcho, "true")
+	}
+	return false
+}
+
+// The resource with the given logical id, nil if the template doesn't contain it
+func (t *Template) FindResource(logicalResourceId string) *TemplateResource {
+	if t == nil {
+		return nil
+	}
+	return t.Resources[logicalResourceId]
+}
+
+// The value of a property of a resource, and whether the property is set
+func (t *Template) ResourceProperty(logicalResourceId string, name string) (interface{}, bool) {
+	resource := t.FindResource(logicalResourceId)
+	if resource == nil {
+		return nil, false
+	}
+	value, present := resource.Properties[name]
+	return value, present
+}
+
+// The policy if it is given, or otherwise the default; empty if the policy cannot be determined
+func effectivePolicy(policy interface{}, defaultPolicy string) string {
+	if policy != nil {
+		s, _ := policy.(string)
+		return s
+	}
+	return defaultPolicy
+}
+
+// The policy applied when the resource gets removed from the stack
+//
+// Without a policy CloudFormation snapshots RDS clusters and instances that are not part of a cluster, and deletes
+// all other resources.
+func (r *TemplateResource) EffectiveDeletionPolicy() string {
+	defaultPolicy := "Delete"
+	switch r.Type {
+	case "AWS::RDS::DBCluster":
+		defaultPolicy = "Snapshot"
+	case "AWS::RDS::DBInstance":
+		if _, present := r.Properties["DBClusterIdentifier"]; !present {
+			defaultPolicy = "Snapshot"
+		}
+	}
+	return effectivePolicy(r.DeletionPolicy, defaultPolicy)
+}
+
+// The policy applied to the old resource when the resource gets replaced
+//
+// CloudFormation only documents the snapshot default for the deletion policy, so without a policy the old
+// resource is assumed to be deleted.
+func (r *TemplateResource) EffectiveUpdateReplacePolicy() string {
+	return effectivePolicy(r.UpdateReplacePolicy, "Delete")
+}
+
+// Format a template value compactly: strings as they are, everything else as JSON
+func FormatTemplateValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(data)
+}
@@ -0,0 +1,235 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func TestParseTemplateIntrinsicFunctions(t *testing.T) {
+	const body = `
+Resources:
+  Instance:
+    Type: AWS::EC2::Instance
+    Properties:
+      InstanceType: !Ref InstanceType
+      SubnetId: !GetAtt Network.Outputs.SubnetId
+      SecurityGroupIds:
+        - !GetAtt [SecurityGroup, GroupId]
+      UserData: !Base64
+        Fn::Sub: "echo ${Purpose}"
+      KeyName: !If [HasKey, !Ref KeyName, !Ref "AWS::NoValue"]
+      Tags:
+        - Key: Name
+          Value: !Sub "${AWS::StackName}-instance"
+      Monitoring: true
+      EbsOptimized: !!str false
+`
+	want := map[string]interface{}{
+		"InstanceType":     map[string]interface{}{"Ref": "InstanceType"},
+		"SubnetId":         map[string]interface{}{"Fn::GetAtt": []interface{}{"Network", "Outputs.SubnetId"}},
+		"SecurityGroupIds": []interface{}{map[string]interface{}{"Fn::GetAtt": []interface{}{"SecurityGroup", "GroupId"}}},
+		"UserData":         map[string]interface{}{"Fn::Base64": map[string]interface{}{"Fn::Sub": "echo ${Purpose}"}},
+		"KeyName": map[string]interface{}{"Fn::If": []interface{}{
+			"HasKey",
+			map[string]interface{}{"Ref": "KeyName"},
+			map[string]interface{}{"Ref": "AWS::NoValue"},
+		}},
+		"Tags":         []interface{}{map[string]interface{}{"Key": "Name", "Value": map[string]interface{}{"Fn::Sub": "${AWS::StackName}-instance"}}},
+		"Monitoring":   true,
+		"EbsOptimized": "false",
+	}
+
+	template, err := ParseTemplate(body)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+	got := template.FindResource("Instance").Properties
+	for name, value := range want {
+		if !reflect.DeepEqual(got[name], value) {
+			t.Errorf("property %s = %#v, want %#v", name, got[name], value)
+		}
+	}
+}
+
+func TestParseTemplateFormats(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr bool
+	}{
+		{"JSON", `{"Resources": {"Topic": {"Type": "AWS::SNS::Topic", "Properties": {"TopicName": {"Fn::Sub": "${AWS::StackName}"}}}}}`, false},
+		{"YAML", "Resources:\n  Topic:\n    Type: AWS::SNS::Topic\n    Properties:\n      TopicName: !Sub ${AWS::StackName}\n", false},
+		{"invalid YAML", "Resources:\n  Topic: [\n", true},
+		{"invalid structure", "Resources: [Topic]\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template, err := ParseTemplate(tt.body)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseTemplate() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTemplate() error = %v", err)
+			}
+			value, present := template.ResourceProperty("Topic", "TopicName")
+			want := map[string]interface{}{"Fn::Sub": "${AWS::StackName}"}
+			if !present || !reflect.DeepEqual(value, want) {
+				t.Errorf("ResourceProperty() = %#v, %v, want %#v", value, present, want)
+			}
+		})
+	}
+}
+
+func TestIsNoEchoParameter(t *testing.T) {
+	template, err := ParseTemplate(`
+Parameters:
+  Password:
+    Type: String
+    NoEcho: true
+  ApiKey:
+    Type: String
+    NoEcho: "TRUE"
+  Quoted:
+    Type: String
+    NoEcho: "false"
+  Disabled:
+    Type: String
+    NoEcho: false
+  Plain:
+    Type: String
+`)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"Password", true},
+		{"ApiKey", true},
+		{"Quoted", false},
+		{"Disabled", false},
+		{"Plain", false},
+		{"Missing", false},
+	}
+	for _, tt := range tests {
+		if got := template.IsNoEchoParameter(tt.name); got != tt.want {
+			t.Errorf("IsNoEchoParameter(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	var missing *Template
+	if missing.IsNoEchoParameter("Password") {
+		t.Errorf("IsNoEchoParameter() = true without a template")
+	}
+}
+
+func TestEffectivePolicies(t *testing.T) {
+	tests := []struct {
+		name                    string
+		resource                TemplateResource
+		wantDeletionPolicy      string
+		wantUpdateReplacePolicy string
+	}{
+		{
+			name:                    "defaults",
+			resource:                TemplateResource{Type: "AWS::S3::Bucket"},
+			wantDeletionPolicy:      "Delete",
+			wantUpdateReplacePolicy: "Delete",
+		},
+		{
+			name:                    "RDS cluster",
+			resource:                TemplateResource{Type: "AWS::RDS::DBCluster"},
+			wantDeletionPolicy:      "Snapshot",
+			wantUpdateReplacePolicy: "Delete",
+		},
+		{
+			name:                    "RDS instance",
+			resource:                TemplateResource{Type: "AWS::RDS::DBInstance"},
+			wantDeletionPolicy:      "Snapshot",
+			wantUpdateReplacePolicy: "Delete",
+		},
+		{
+			name:                    "RDS instance in a cluster",
+			resource:                TemplateResource{Type: "AWS::RDS::DBInstance", Properties: map[string]interface{}{"DBClusterIdentifier": map[string]interface{}{"Ref": "Cluster"}}},
+			wantDeletionPolicy:      "Delete",
+			wantUpdateReplacePolicy: "Delete",
+		},
+		{
+			name:                    "explicit policies",
+			resource:                TemplateResource{Type: "AWS::RDS::DBInstance", DeletionPolicy: "Retain", UpdateReplacePolicy: "Snapshot"},
+			wantDeletionPolicy:      "Retain",
+			wantUpdateReplacePolicy: "Snapshot",
+		},
+		{
+			name:                    "unresolved policies",
+			resource:                TemplateResource{Type: "AWS::S3::Bucket", DeletionPolicy: map[string]interface{}{"Fn::If": []interface{}{"IsProd", "Retain", "Delete"}}, UpdateReplacePolicy: map[string]interface{}{"Ref": "Policy"}},
+			wantDeletionPolicy:      "",
+			wantUpdateReplacePolicy: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.resource.EffectiveDeletionPolicy(); got != tt.wantDeletionPolicy {
+				t.Errorf("EffectiveDeletionPolicy() = %q, want %q", got, tt.wantDeletionPolicy)
+			}
+			if got := tt.resource.EffectiveUpdateReplacePolicy(); got != tt.wantUpdateReplacePolicy {
+				t.Errorf("EffectiveUpdateReplacePolicy() = %q, want %q", got, tt.wantUpdateReplacePolicy)
+			}
+		})
+	}
+}
+
+func TestResourceFate(t *testing.T) {
+	const currentTemplate = `
+Resources:
+  Database:
+    Type: AWS::RDS::DBInstance
+  Logs:
+    Type: AWS::S3::Bucket
+    DeletionPolicy: Retain
+  Queue:
+    Type: AWS::SQS::Queue
+`
+	const template = `
+Resources:
+  Database:
+    Type: AWS::RDS::DBInstance
+  Logs:
+    Type: AWS::S3::Bucket
+    UpdateReplacePolicy: Retain
+`
+	tests := []struct {
+		name            string
+		change          types.Change
+		wantFate        ResourceFate
+		wantDescription string
+	}{
+		{"removed with default policy", testChange("Database", "AWS::RDS::DBInstance", types.ChangeActionRemove, ""), ResourceFateSnapshotted, "the resource " + string(ResourceFateSnapshotted)},
+		{"removed with explicit policy", testChange("Logs", "AWS::S3::Bucket", types.ChangeActionRemove, ""), ResourceFateRetained, "the resource " + string(ResourceFateRetained)},
+		{"replaced with default policy", testChange("Database", "AWS::RDS::DBInstance", types.ChangeActionModify, types.ReplacementTrue), ResourceFateDeleted, "the old resource " + string(ResourceFateDeleted)},
+		{"conditionally replaced", testChange("Logs", "AWS::S3::Bucket", types.ChangeActionModify, types.ReplacementConditional), ResourceFateRetained, "if replaced, the old resource " + string(ResourceFateRetained)},
+		{"replaced, only in the current template", testChange("Queue", "AWS::SQS::Queue", types.ChangeActionModify, types.ReplacementTrue), ResourceFateDeleted, "the old resource " + string(ResourceFateDeleted)},
+		{"modified", testChange("Database", "AWS::RDS::DBInstance", types.ChangeActionModify, types.ReplacementFalse), "", ""},
+		{"not in the templates", testChange("Topic", "AWS::SNS::Topic", types.ChangeActionRemove, ""), "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := testTree("Stack", tt.change)
+			tree.TemplateBody = template
+			tree.CurrentTemplateBody = currentTemplate
+			r := newTestModel(t, tree).Root.Resources[0]
+			if got := r.Fate(); got != tt.wantFate {
+				t.Errorf("Fate() = %q, want %q", got, tt.wantFate)
+			}
+			if got := r.FateDescription(); got != tt.wantDescription {
+				t.Errorf("FateDescription() = %q, want %q", got, tt.wantDescription)
+			}
+		})
+	}
+}